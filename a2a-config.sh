@@ -1,5 +1,6 @@
 #!/usr/bin/env bash
 # Creates the a2a configuration, removes the existing one
+# Deprecated: copy a configuration file with ApiTokenFile into the machine, see Vagrant Mode in the README.
 [ -f /etc/a2a/config ]  && rm -f /etc/a2a/config
 [ -f $HOME/.a2a/config ] && rm -f $HOME/.a2a/config
 [ -d /etc/a2a ] && rm -rf /etc/a2a
@@ -6,30 +6,29 @@ package main
 
 import (
 	"./alertmanager/config"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/uniwue-rz/phabricator-go"
-	"github.com/urfave/cli"
-	"gopkg.in/gcfg.v1"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
-	"os/user"
+	"os/signal"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
 
 const cacheFile = "a2a_cache"
 
-// AnsiblePlaybook will only be read in the Vagrant mode.
+// AnsiblePlaybook is a play of the playbook, it will only be read in the Vagrant mode.
 type AnsiblePlaybook struct {
-	Host string `yaml:"hosts"`
+	Hosts          HostPatterns `yaml:"hosts"`
+	ImportPlaybook string       `yaml:"import_playbook"`
+	// BuiltinImportPlaybook is import_playbook with the fully qualified name.
+	BuiltinImportPlaybook string `yaml:"ansible.builtin.import_playbook"`
 }
 
 // Configuration is managed using this struct
@@ -37,6 +36,18 @@ type Configuration struct {
 	Phabricator struct {
 		ApiToken string
 		ApiURL   string
+		// ApiTokenFile is a file which contains the API token.
+		ApiTokenFile string
+		// ApiTokenCommand is a shell command which prints the API token.
+		ApiTokenCommand string
+		// Timeout is the overall deadline of a run, ex. 2m. It is not set per default.
+		Timeout string
+		// RequestTimeout is the deadline of every single Conduit request, ex. 30s.
+		RequestTimeout string
+		// Retries is the number of retries for the read calls.
+		Retries int
+		// Backoff is the initial wait time between the retries, ex. 500ms.
+		Backoff string
 	}
 	Ansible struct {
 		Playbook string
@@ -45,6 +56,96 @@ type Configuration struct {
 		Passphrase string
 		Json       string
 	}
+	Log struct {
+		// Format is either text (default) or json.
+		Format string
+	}
+	Inventory struct {
+		// Partial builds the inventory even when single hosts or properties fail.
+		Partial bool
+		// MaxErrors is the number of problems allowed in partial mode before a2a exits non-zero, -1 (default) allows any number.
+		MaxErrors int
+		// Broken is either omit (default) or flag and defines what happens to the hosts with problems.
+		Broken string
+	}
+	Vagrant struct {
+		// Mapping is a YAML file with the machines of a multi-machine Vagrant environment.
+		Mapping string
+	}
+	// Keyed contains the keyed groups, ex. [Keyed "os"].
+	Keyed map[string]*KeyedGroup
+	// Compose contains the composed host variables, ex. [Compose "fqdn"].
+	Compose map[string]*ComposedVar
+	Template struct {
+		// Enabled expands the Go templates in the property values, ex. {{ .host.name }}.backup.{{ .domain }}.
+		Enabled bool
+		// Left and Right are the delimiters of the templates, {{ and }} per default.
+		Left  string
+		Right string
+	}
+	Snapshot struct {
+		// Directory keeps a snapshot of every new inventory, they are not written when it is not set.
+		Directory string
+		// Keep is the number of snapshots kept, 0 keeps all.
+		Keep int
+	}
+	Status struct {
+		// Property is the device property with the status of the host, a2a-status per default.
+		Property string
+		// Inventory, Prometheus and Blackbox are the actions for the inactive hosts: drop (default), maintenance or label.
+		Inventory  string
+		Prometheus string
+		Blackbox   string
+		// Group is the group of the inactive hosts in the maintenance action, maintenance per default.
+		Group string
+	}
+	Bindings struct {
+		// Flatten adds the binding properties to the host variables instead of a2a_bindings.<group>.
+		Flatten bool
+	}
+	AutoGroups struct {
+		// Network adds a group for every Almanac network of the bindings, ex. net_dmz.
+		Network       bool
+		NetworkPrefix string
+		// Project adds a group for every project tag of the devices, ex. project_webteam.
+		Project       bool
+		ProjectPrefix string
+		// Namespace adds a group for the Almanac namespace of every device, ex. ns_dmz_example_com.
+		Namespace       bool
+		NamespacePrefix string
+	}
+	Import struct {
+		// Network is the Almanac network of the interfaces created by a2a import.
+		Network string
+		// Secret matches the keys of the variables that are not imported as properties.
+		Secret string
+	}
+	Facts struct {
+		// Directory is the fact cache of the Ansible jsonfile plugin, fact_caching_connection in ansible.cfg.
+		Directory string
+		// Prefix of the device properties with the facts, fact- per default.
+		Prefix string
+	}
+	// Fact contains the facts written to the devices, ex. [Fact "kernel"]. Without one the default facts are used.
+	Fact    map[string]*FactSource
+	Overlay struct {
+		// File is a YAML overlay, it can be given several times and is applied in the given order.
+		File []string
+	}
+	Cache struct {
+		// Namespace keeps the cache apart from the ones of other configurations.
+		Namespace string
+	}
+	// Profile contains the named profiles, ex. [Profile "prod"].
+	Profile map[string]*Profile
+	Merge   struct {
+		// Profile lists the profiles that are merged to one inventory.
+		Profile []string
+		// Conflict is prefer (default), error or rename and defines what happens to the hosts found in several profiles.
+		Conflict string
+		// Prefer is the profile that wins the conflicts, the first profile per default.
+		Prefer string
+	}
 }
 
 // Output is used to encode the data for the output of the application
@@ -52,6 +153,7 @@ type Output struct {
 	Group map[string]Group
 	Meta  struct {
 		HostVars map[string]map[string]interface{} `json:"hostvars, omitifempty"`
+		Errors   []InventoryError                  `json:"a2a_errors,omitempty"`
 	} `json:"_meta"`
 }
 
@@ -60,6 +162,20 @@ type BlackboxInput struct {
 	Targets []string `json:"targets"`
 }
 
+// PrometheusInput is an item of the prometheus-config property
+type PrometheusInput struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+// AlertManagerInput is an item of the alertmanager-config property
+type AlertManagerInput struct {
+	Name           string                 `json:"name"`
+	Type           string                 `json:"type"`
+	ReceiverConfig map[string]interface{} `json:"receiver-config"`
+	MatchingConfig map[string]string      `json:"matching-config"`
+}
+
 // PrometheusOutput is used to create
 // the output that can be read by prometheus
 type PrometheusOutput struct {
@@ -69,103 +185,87 @@ type PrometheusOutput struct {
 
 // readAlertManagerConfig Reads the alert manager config file and returns
 // The configuration data and content as byte. If there is an error
-// reading the file it panics
+// reading the file a configuration error is returned
 func readAlertManagerConfig(path string) (*config.Config, []byte, error) {
 	dataConfig, content, err := config.LoadFile(path)
 	if err != nil {
-		panic(err)
+		return dataConfig, content, NewError(ConfigError, err, "can not read the alertmanager configuration %s", path)
 	}
 
 	return dataConfig, content, err
 }
 
-func manageAlertManager(p *phabricator.Phabricator, configPath string, jsonWrapper string) {
-	dataConfig, _, err := readAlertManagerConfig(configPath)
-	if err != nil {
-		panic(err)
+// manageAlertManager adds the routes and receivers of the groups to the given alertmanager
+// configuration and prints it. The groups with an invalid alertmanager-config are skipped.
+func manageAlertManager(dataConfig *config.Config, output Output, jsonWrapper string, maxErrors int) error {
+	routes, receivers, problems := getGroupRouteReceivers(output, jsonWrapper)
+	if err := reportProblems(problems, maxErrors); err != nil {
+		return err
 	}
-	routes, receivers := getGroupRouteReceivers(p, jsonWrapper)
 	dataConfig = addRouteReceivers(dataConfig, routes, receivers)
 	fmt.Println(dataConfig)
+	return nil
 }
 
-func getGroupRouteReceivers(p *phabricator.Phabricator, jsonWrapper string) (routes []config.Route, receivers []config.Receiver) {
-	services, err := p.GetServicesAsync()
-
-	if err != nil {
-		panic(err)
-	}
-
-	for _, d := range services.Result.Data {
-		matchArray := make(map[string]string)
-		groupName := d.Fields.Name
-		matchArray["group"] = groupName
-		for _, property := range d.Attachments.Properties.Properties {
-			if property.Key == "alertmanager-config" {
-				val, isJson, _ := HandleJson(jsonWrapper, property.Value)
-				if isJson {
-					for _, data := range val.([]interface{}) {
-						name, nameOk := data.(map[string]interface{})["name"]
-						alertType, alertTypeOk := data.(map[string]interface{})["type"]
-						receiverConfig, receiverConfigOK := data.(map[string]interface{})["receiver-config"]
-						matchInConfig, matchingConfigOk := data.(map[string]interface{})["matching-config"]
-						// Adds the matching config to the match array if extra information exist
-						if matchingConfigOk {
-							for k, val := range matchInConfig.(map[string]string) {
-								// The group can not be changed. It is a security
-								// feature added so the groups always match Almanac
-								if k != "group" {
-									matchArray[k] = val
-								}
-							}
+// getGroupRouteReceivers returns the routes and receivers of the groups with an alertmanager-config.
+// The groups with an invalid value are skipped and returned as problems.
+func getGroupRouteReceivers(output Output, jsonWrapper string) (routes []config.Route, receivers []config.Receiver, problems []InventoryError) {
+	for _, groupName := range output.GroupNames() {
+		if alertManagerConfig, ok := output.Group[groupName].Vars["alertmanager_config"].(string); ok {
+			var alertManagerJson []AlertManagerInput
+			isJson, err := decodeMonitoringConfig("alertmanager_config", alertManagerConfig, jsonWrapper, &alertManagerJson)
+			if err != nil {
+				problems = append(problems, InventoryError{Group: groupName, Property: "alertmanager-config", Reason: err.Error(), err: err})
+				continue
+			}
+			if isJson {
+				for _, data := range alertManagerJson {
+					matchArray := make(map[string]string)
+					// Adds the matching config to the match array if extra information exist
+					for k, val := range data.MatchingConfig {
+						matchArray[k] = val
+					}
+					// The group can not be changed. It is a security
+					// feature added so the groups always match Almanac
+					matchArray["group"] = groupName
+					// The is marked by the A2A so it can be found again
+					receiverName := "dynamic-" + groupName + "-" + data.Type + "-" + data.Name
+					route := config.Route{
+						Receiver: receiverName,
+						Match:    matchArray,
+					}
+					routes = append(routes, route)
+					receiver := config.Receiver{Name: receiverName}
+					if data.Type == "email" {
+						emailConfig := config.EmailConfig{}
+						if toEmail, toEmailOK := data.ReceiverConfig["to"].(string); toEmailOK {
+							emailConfig.To = toEmail
 						}
-						if nameOk && alertTypeOk && receiverConfigOK {
-							// The is marked by the A2A so it can be found again
-							receiverName := "dynamic-" + groupName + "-" + alertType.(string) + "-" + name.(string)
-							route := config.Route{
-								Receiver: receiverName,
-								Match:    matchArray,
-							}
-							routes = append(routes, route)
-							receiver := config.Receiver{Name: receiverName}
-							if alertType == "email" {
-								toEmail, toEmailOK := receiverConfig.(map[string]interface{})["to"]
-								emailConfig := config.EmailConfig{}
-								if toEmailOK {
-									emailConfig.To = toEmail.(string)
-								}
-								textEmail, textEmailOk := receiverConfig.(map[string]interface{})["text"]
-								if textEmailOk {
-									emailConfig.Text = textEmail.(string)
-								}
-								requireTLS, requireTLSOk := receiverConfig.(map[string]interface{})["require-tls"]
-								emailConfig.RequireTLS = new(bool)
-								if requireTLSOk {
-									if requireTLS.(string) == "false" {
-										* emailConfig.RequireTLS = false
-									} else {
-										* emailConfig.RequireTLS = true
-									}
-								} else {
-									* emailConfig.RequireTLS = false
-								}
-								sendResolved, sendResolvedOk := receiverConfig.(map[string]interface{})["send-resolved"]
-								emailConfig.VSendResolved = true
-								if sendResolvedOk {
-									if sendResolved.(string) == "false" {
-										emailConfig.VSendResolved = false
-									}
-								}
-								receiver.EmailConfigs = append(receiver.EmailConfigs, &emailConfig)
-							}
-							receivers = append(receivers, receiver)
+						if textEmail, textEmailOk := data.ReceiverConfig["text"].(string); textEmailOk {
+							emailConfig.Text = textEmail
 						}
+						emailConfig.RequireTLS = new(bool)
+						*emailConfig.RequireTLS = receiverFlag(data.ReceiverConfig, "require-tls", false)
+						emailConfig.VSendResolved = receiverFlag(data.ReceiverConfig, "send-resolved", true)
+						receiver.EmailConfigs = append(receiver.EmailConfigs, &emailConfig)
 					}
+					receivers = append(receivers, receiver)
 				}
 			}
 		}
 	}
-	return routes, receivers
+	return routes, receivers, problems
+}
+
+// receiverFlag reads a flag of a receiver-config, the flags are booleans or the strings "true" and "false".
+func receiverFlag(receiverConfig map[string]interface{}, key string, fallback bool) bool {
+	switch value := receiverConfig[key].(type) {
+	case bool:
+		return value
+	case string:
+		return value != "false"
+	}
+	return fallback
 }
 
 // addRouteReceivers Adds the routes and receivers to the existing configuration.
@@ -222,30 +322,44 @@ func (output *Output) Sanitize() (data map[string]interface{}) {
 type Group struct {
 	Hosts []string               `json:"hosts, omitifempty"`
 	Vars  map[string]interface{} `json:"vars, omitifempty"`
+	// Addresses are the interface addresses of the bindings, they are used for the prometheus targets.
+	Addresses map[string]string `json:"-"`
+	// Networks are the Almanac networks of the bindings, they are used for the network groups.
+	Networks map[string]string `json:"-"`
+	// Bindings are the properties of the bindings of the hosts, they are added to the host variables.
+	Bindings map[string]map[string]interface{} `json:"-"`
+	// Status are the statuses of the inactive hosts, ex. disabled for a disabled binding.
+	Status map[string]string `json:"-"`
 }
 
-// AddHost adds a new host to the given host group in the output
+// AddHost adds a new host to the given host group in the output, the group is created when it does not exist.
 func (output *Output) AddHost(host string, groupName string) {
-	for k, v := range output.Group {
-		if k == groupName {
-			v.Hosts = append(v.Hosts, host)
-			output.Group[k] = v
-		}
+	if output.Group == nil {
+		output.Group = make(map[string]Group)
 	}
+	group, found := output.Group[groupName]
+	if !found {
+		group = Group{Hosts: []string{}, Vars: make(map[string]interface{})}
+	}
+	if !containsString(group.Hosts, host) {
+		group.Hosts = append(group.Hosts, host)
+	}
+	output.Group[groupName] = group
 }
 
 // Save the result json data in the cache.
 // If the cache is not to old this will be returned as the result
-func saveCache(jsonData []byte, path string) {
+func saveCache(jsonData []byte, path string) error {
 	cacheFileObject, err := getTempFilePath(path)
 	if err != nil {
-		panic(err)
+		return NewError(OutputError, err, "can not create the cache file")
 	}
 	thePath, err := filepath.Abs(cacheFileObject.Name())
 	err = ioutil.WriteFile(thePath, jsonData, 0644)
 	if err != nil {
-		panic(err)
+		return NewError(OutputError, err, "can not write the cache file %s", thePath)
 	}
+	return nil
 }
 
 // Reads the cache file and see if is still valid
@@ -258,7 +372,7 @@ func readCache(path string, cacheAge int) (jsonData []byte, cacheStatus bool, er
 	if err != nil {
 		return jsonData, false, err
 	}
-	thePath, err := filepath.Abs(filepath.Dir(cacheFileObject.Name()))
+	thePath, err := filepath.Abs(cacheFileObject.Name())
 	if err != nil {
 		return jsonData, false, err
 	}
@@ -284,26 +398,63 @@ func readCache(path string, cacheAge int) (jsonData []byte, cacheStatus bool, er
 	}
 }
 
+// augmentVars resolves the passphrases and decodes the json values of the given variables.
+// The variables that can not be resolved are removed and returned as problems.
+func augmentVars(ctx context.Context, p *Conduit, PassphraseWrapper string, JsonWrapper string, vars map[string]interface{}) (problems []InventoryError) {
+	for i, j := range vars {
+		if nested, ok := j.(map[string]interface{}); ok {
+			for _, problem := range augmentVars(ctx, p, PassphraseWrapper, JsonWrapper, nested) {
+				problem.Property = i + "." + problem.Property
+				problems = append(problems, problem)
+			}
+			continue
+		}
+		value, ok := j.(string)
+		if !ok {
+			continue
+		}
+		passphrase, isPassphrase, err := HandlePassphrase(ctx, p, PassphraseWrapper, value)
+		if err != nil {
+			delete(vars, i)
+			problems = append(problems, InventoryError{Property: i, Reason: err.Error(), err: err})
+			continue
+		}
+		if isPassphrase {
+			vars[i] = passphrase
+		}
+		jsonData, isJson, err := HandleJson(JsonWrapper, value)
+		if err != nil {
+			delete(vars, i)
+			err = NewError(DataError, err, "invalid json in %s", i)
+			problems = append(problems, InventoryError{Property: i, Reason: err.Error(), err: err})
+			continue
+		}
+		if isJson {
+			vars[i] = jsonData
+		}
+	}
+	return problems
+}
+
 // Augment adds the result from passphrase and sanitizes the json results, so everything looks polished.
-func (output *Output) AugmentParallel(p *phabricator.Phabricator, PassphraseWrapper string, JsonWrapper string) {
+// The properties that can not be resolved are removed and returned as problems.
+func (output *Output) AugmentParallel(ctx context.Context, p *Conduit, PassphraseWrapper string, JsonWrapper string) (problems []InventoryError) {
 
 	var wg sync.WaitGroup
+	var mutex sync.Mutex
 	wg.Add(len(output.Meta.HostVars))
 
 	var hostVarsSyncMap sync.Map
 	for k, v := range output.Meta.HostVars {
 		go func(k string, v map[string]interface{}) {
 			defer wg.Done()
-			for i, j := range v {
-				passphrase, isPassphrase, _ := HandlePassphrase(p, PassphraseWrapper, j.(string))
-				if isPassphrase {
-					v[i] = passphrase
-				}
-				jsonData, isJson, _ := HandleJson(JsonWrapper, j.(string))
-				if isJson {
-					v[i] = jsonData
-				}
+			hostProblems := augmentVars(ctx, p, PassphraseWrapper, JsonWrapper, v)
+			mutex.Lock()
+			for _, problem := range hostProblems {
+				problem.Device = k
+				problems = append(problems, problem)
 			}
+			mutex.Unlock()
 
 			hostVarsSyncMap.Store(k, v)
 
@@ -320,16 +471,13 @@ func (output *Output) AugmentParallel(p *phabricator.Phabricator, PassphraseWrap
 	for k, v := range output.Group {
 		go func(k string, v Group) {
 			defer wg.Done()
-			for i, j := range v.Vars {
-				passphrase, isPassphrase, _ := HandlePassphrase(p, PassphraseWrapper, j.(string))
-				if isPassphrase {
-					v.Vars[i] = passphrase
-				}
-				jsonData, isJson, _ := HandleJson(JsonWrapper, j.(string))
-				if isJson {
-					v.Vars[i] = jsonData
-				}
+			groupProblems := augmentVars(ctx, p, PassphraseWrapper, JsonWrapper, v.Vars)
+			mutex.Lock()
+			for _, problem := range groupProblems {
+				problem.Group = k
+				problems = append(problems, problem)
 			}
+			mutex.Unlock()
 			outputGroupSyncMap.Store(k, v)
 		}(k, v)
 	}
@@ -338,172 +486,145 @@ func (output *Output) AugmentParallel(p *phabricator.Phabricator, PassphraseWrap
 		output.Group[k.(string)] = v.(Group)
 		return true
 	})
+	return problems
 }
 
 // Augment adds the result from passphrase and sanitizes the json results, so everything looks polished.
-func (output *Output) AugmentBlocking(p *phabricator.Phabricator, PassphraseWrapper string, JsonWrapper string) {
+// The properties that can not be resolved are removed and returned as problems.
+func (output *Output) AugmentBlocking(ctx context.Context, p *Conduit, PassphraseWrapper string, JsonWrapper string) (problems []InventoryError) {
 	for k, v := range output.Meta.HostVars {
-		for i, j := range v {
-			passphrase, isPassphrase, _ := HandlePassphrase(p, PassphraseWrapper, j.(string))
-			if isPassphrase {
-				v[i] = passphrase
-			}
-			jsonData, isJson, _ := HandleJson(JsonWrapper, j.(string))
-			if isJson {
-				v[i] = jsonData
-			}
+		for _, problem := range augmentVars(ctx, p, PassphraseWrapper, JsonWrapper, v) {
+			problem.Device = k
+			problems = append(problems, problem)
 		}
 
 		output.Meta.HostVars[k] = v
 	}
 
 	for k, v := range output.Group {
-		for i, j := range v.Vars {
-			passphrase, isPassphrase, _ := HandlePassphrase(p, PassphraseWrapper, j.(string))
-			if isPassphrase {
-				v.Vars[i] = passphrase
-			}
-			jsonData, isJson, _ := HandleJson(JsonWrapper, j.(string))
-			if isJson {
-				v.Vars[i] = jsonData
-			}
+		for _, problem := range augmentVars(ctx, p, PassphraseWrapper, JsonWrapper, v.Vars) {
+			problem.Group = k
+			problems = append(problems, problem)
 		}
 		output.Group[k] = v
 	}
+	return problems
 }
 
-func (output *Output) Augment(p *phabricator.Phabricator, PassphraseWrapper string, JsonWrapper string) {
-	output.AugmentParallel(p, PassphraseWrapper, JsonWrapper)
+func (output *Output) Augment(ctx context.Context, p *Conduit, PassphraseWrapper string, JsonWrapper string) (problems []InventoryError) {
+	return output.AugmentParallel(ctx, p, PassphraseWrapper, JsonWrapper)
 }
 
-// GetBlackBoxData returns the blackbox targets and data.
-func GetBlackBoxData(p *phabricator.Phabricator, JsonWrapper string, ignoreArray []string) (allOutputs []PrometheusOutput, err error) {
-	services, err := p.GetServicesAsync()
-
-	allOutputs = make([]PrometheusOutput, 0)
-	if err != nil {
-		return allOutputs, err
+// GroupNames returns the names of the groups in sorted order.
+func (output *Output) GroupNames() []string {
+	names := make([]string, 0, len(output.Group))
+	for name := range output.Group {
+		names = append(names, name)
 	}
-	for _, d := range services.Result.Data {
-		ignored := false
-		for _, b := range ignoreArray {
-			if b == d.Fields.Name && ignored == false {
-				ignored = true
-			}
-		}
-		if len(d.Attachments.Bindings.Bindings) != 0 && ignored == false {
-			blackBoxConfig := ""
-			for _, property := range d.Attachments.Properties.Properties {
-				if property.Key == "blackbox-config" {
-					blackBoxConfig = property.Value
-				}
-			}
-			for _, v := range d.Attachments.Bindings.Bindings {
-				host, err := CreateHost(p, v.Interface.Device.Name)
+	sort.Strings(names)
+	return names
+}
 
-				if val, ok := host["blackbox_config"]; ok {
-					blackBoxConfig = val.(string)
-				}
+// isIgnored returns true if the group is in the ignored groups.
+func isIgnored(groupName string, ignoreArray []string) bool {
+	for _, b := range ignoreArray {
+		if b == groupName {
+			return true
+		}
+	}
+	return false
+}
 
-				_, isJson, err := HandleJson(JsonWrapper, blackBoxConfig)
-				if err != nil {
-					panic(err)
-				}
+// hostConfig returns the given property of the host or, when the host does not have it, of the group.
+func hostConfig(output Output, group Group, host string, key string) string {
+	if val, ok := output.Meta.HostVars[host][key].(string); ok {
+		return val
+	}
+	val, _ := group.Vars[key].(string)
+	return val
+}
 
-				if isJson {
-					var blackBoxJson []BlackboxInput
-					err := json.Unmarshal([]byte(blackBoxConfig), &blackBoxJson)
-					if err != nil {
-						panic(err)
-					}
-					for _, blackbox := range blackBoxJson {
-						labels := make(map[string]string, 0)
-						group := d.Fields.Name
-						labels["module"] = blackbox.Module
-						labels["job"] = "blackbox"
-						labels["group"] = group
-						labels["ip"] = v.Interface.Address
-						labels["host"] = v.Interface.Device.Name
-						targets := blackbox.Targets
-						prometheusOutput := PrometheusOutput{
-							Labels:  labels,
-							Targets: targets}
-						allOutputs = append(allOutputs, prometheusOutput)
+// GetBlackBoxData returns the blackbox targets and data. The hosts with an invalid blackbox-config
+// are skipped and returned as problems.
+func GetBlackBoxData(output Output, JsonWrapper string, ignoreArray []string) (allOutputs []PrometheusOutput, problems []InventoryError) {
+	allOutputs = make([]PrometheusOutput, 0)
+	for _, groupName := range output.GroupNames() {
+		group := output.Group[groupName]
+		if len(group.Hosts) == 0 || isIgnored(groupName, ignoreArray) {
+			continue
+		}
+		for _, host := range group.Hosts {
+			blackBoxConfig := hostConfig(output, group, host, "blackbox_config")
+			var blackBoxJson []BlackboxInput
+			isJson, err := decodeMonitoringConfig("blackbox_config", blackBoxConfig, JsonWrapper, &blackBoxJson)
+			if err != nil {
+				problems = append(problems, InventoryError{Device: host, Group: groupName, Property: "blackbox-config", Reason: err.Error(), err: err})
+				continue
+			}
+			if isJson {
+				for _, blackbox := range blackBoxJson {
+					labels := make(map[string]string, 0)
+					labels["module"] = blackbox.Module
+					labels["job"] = "blackbox"
+					labels["group"] = groupName
+					labels["ip"] = group.Addresses[host]
+					labels["host"] = host
+					if status := group.Status[host]; status != "" {
+						labels["status"] = status
 					}
-
+					targets := blackbox.Targets
+					prometheusOutput := PrometheusOutput{
+						Labels:  labels,
+						Targets: targets}
+					allOutputs = append(allOutputs, prometheusOutput)
 				}
 			}
-
 		}
 	}
-	return allOutputs, err
+	return allOutputs, problems
 }
 
 // GetPrometheusData returns the monitoring data for every host and group. If the host has its own
 // prometheus-config this will be used, when not the group settings will be used.
-// The script will be used here to create the dynamic configuration in Prometheus
-func GetPrometheusData(p *phabricator.Phabricator, JsonWrapper string, ignoreArray []string) (allOutputs []PrometheusOutput, err error) {
-	services, err := p.GetServicesAsync()
-
+// The script will be used here to create the dynamic configuration in Prometheus. The hosts with an
+// invalid prometheus-config are skipped and returned as problems.
+func GetPrometheusData(output Output, JsonWrapper string, ignoreArray []string) (allOutputs []PrometheusOutput, problems []InventoryError) {
 	allOutputs = make([]PrometheusOutput, 0)
-	if err != nil {
-		return allOutputs, err
-	}
-	for _, d := range services.Result.Data {
-		ignored := false
-		for _, b := range ignoreArray {
-			if b == d.Fields.Name && ignored == false {
-				ignored = true
-			}
+	for _, groupName := range output.GroupNames() {
+		group := output.Group[groupName]
+		if len(group.Hosts) == 0 || isIgnored(groupName, ignoreArray) {
+			continue
 		}
-		if len(d.Attachments.Bindings.Bindings) != 0 && ignored == false {
-			prometheusConfig := ""
-			groupPrometheusConfig := ""
-			for _, property := range d.Attachments.Properties.Properties {
-				if property.Key == "prometheus-config" {
-					prometheusConfig = property.Value
-					groupPrometheusConfig = property.Value
-				}
+		for _, host := range group.Hosts {
+			prometheusConfig := hostConfig(output, group, host, "prometheus_config")
+			var prometheusJson []PrometheusInput
+			isJson, err := decodeMonitoringConfig("prometheus_config", prometheusConfig, JsonWrapper, &prometheusJson)
+			if err != nil {
+				problems = append(problems, InventoryError{Device: host, Group: groupName, Property: "prometheus-config", Reason: err.Error(), err: err})
+				continue
 			}
-			for _, v := range d.Attachments.Bindings.Bindings {
-				host, err := CreateHost(p, v.Interface.Device.Name)
-
-				if val, ok := host["prometheus_config"]; ok {
-					prometheusConfig = val.(string)
-				}else{
-					prometheusConfig = groupPrometheusConfig
-				}
-
-				m, isJson, err := HandleJson(JsonWrapper, prometheusConfig)
-				if err != nil {
-					panic(err)
-				}
-				if isJson {
-					for _, data := range m.([]interface{}) {
-						name, nameOk := data.(map[string]interface{})["name"]
-						port, portOk := data.(map[string]interface{})["port"]
-						if nameOk && portOk {
-							targets := make([]string, 0)
-							target := v.Interface.Address + ":" +
-								strconv.FormatFloat(port.(float64), 'f', -1, 64)
-							targets = append(targets, target)
-							group := d.Fields.Name
-							labels := make(map[string]string, 0)
-							labels["job"] = name.(string)
-							labels["group"] = group
-							labels["ip"] = v.Interface.Address
-							labels["host"] = v.Interface.Device.Name
-							prometheusOutput := PrometheusOutput{
-								Labels:  labels,
-								Targets: targets}
-							allOutputs = append(allOutputs, prometheusOutput)
-						}
+			if isJson {
+				for _, data := range prometheusJson {
+					targets := make([]string, 0)
+					target := group.Addresses[host] + ":" + strconv.Itoa(data.Port)
+					targets = append(targets, target)
+					labels := make(map[string]string, 0)
+					labels["job"] = data.Name
+					labels["group"] = groupName
+					labels["ip"] = group.Addresses[host]
+					labels["host"] = host
+					if status := group.Status[host]; status != "" {
+						labels["status"] = status
 					}
+					prometheusOutput := PrometheusOutput{
+						Labels:  labels,
+						Targets: targets}
+					allOutputs = append(allOutputs, prometheusOutput)
 				}
 			}
 		}
 	}
-	return allOutputs, err
+	return allOutputs, problems
 }
 
 // HandleJson returns converts the json to representable strings
@@ -518,22 +639,36 @@ func HandleJson(JsonWrapper string, propertyKey string) (m interface{}, isJson b
 	return m, isJson, err
 }
 
+// passphraseName returns the monogram of a passphrase reference from the name capture group
+// of the wrapper. A wrapper without the name group uses its first group.
+func passphraseName(wrapper *regexp.Regexp, value string) (name string, found bool) {
+	index := wrapper.SubexpIndex("name")
+	if index < 1 {
+		index = 1
+	}
+	matches := wrapper.FindStringSubmatch(value)
+	if len(matches) <= index {
+		return "", false
+	}
+	return matches[index], true
+}
+
 // HandlePassphrase returns the passphrase for the given system
-func HandlePassphrase(p *phabricator.Phabricator, PassphraseWrapper string, propertyKey string) (passPhrase string, isPassphrase bool, err error) {
+func HandlePassphrase(ctx context.Context, p *Conduit, PassphraseWrapper string, propertyKey string) (passPhrase string, isPassphrase bool, err error) {
 	isPassphrase = false
 	passPhraseRegex := regexp.MustCompile(PassphraseWrapper)
 	if passPhraseRegex.MatchString(propertyKey) {
-		passPhraseRegexMatching := passPhraseRegex.FindStringSubmatch(propertyKey)
-		if len(passPhraseRegexMatching) > 1 {
+		if passPhraseKey, ok := passphraseName(passPhraseRegex, propertyKey); ok {
 			isPassphrase = true
-			passPhraseKey := passPhraseRegexMatching[1]
-			passphraseObj, err := p.GetPassPhraseWithId(passPhraseKey)
+			passphrases, err := p.GetPassphrase(ctx, passPhraseKey)
 			if err != nil {
 				passPhrase = ""
 				return passPhrase, false, err
 			}
-			for _, passphraseItem := range passphraseObj.Result.Data {
+			found := false
+			for _, passphraseItem := range passphrases {
 				if passphraseItem.Monogram == passPhraseKey {
+					found = true
 					if passphraseItem.Material.Password != "" {
 						passPhrase = passphraseItem.Material.Password
 					}
@@ -542,6 +677,11 @@ func HandlePassphrase(p *phabricator.Phabricator, PassphraseWrapper string, prop
 					}
 				}
 			}
+			if !found {
+				return passPhrase, false, NewError(DataError, nil, "passphrase %s is not found or has no Conduit access", passPhraseKey)
+			}
+			rememberSecret(passPhrase)
+			logger.Info("passphrase resolved", "monogram", passPhraseKey)
 
 		}
 	}
@@ -549,31 +689,58 @@ func HandlePassphrase(p *phabricator.Phabricator, PassphraseWrapper string, prop
 	return passPhrase, isPassphrase, err
 }
 
-//List Returns the json list of hosts and their properties
-func ListParallel(p *phabricator.Phabricator, playBookPath string, vagrant string) (output Output, err error) {
+//List Returns the json list of hosts and their properties. In partial mode the hosts
+// that can not be read are kept without variables and the problem is added to _meta.a2a_errors.
+func ListParallel(ctx context.Context, p *Conduit, playBookPath string, vagrant string, partial bool) (output Output, err error) {
 
 	groupList := make(map[string]Group)
 	hostVars := make(map[string]map[string]interface{})
 
-	services, err := p.GetServicesAsync() // -> one request, not worth paralleling
+	services, err := p.GetServices(ctx) // -> one request, not worth paralleling
 
 	// Returns the List of services
 	if err != nil {
-		panic(err)
+		return output, err
 	}
 
+	// The maps are written from every goroutine, the first error stops the list.
+	var mutex sync.Mutex
+	var firstErr error
+	var problems []InventoryError
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	type empty struct{}
-	amountOfResultData := len(services.Result.Data)
+	amountOfResultData := len(services)
 	sem := make(chan empty, amountOfResultData) // semaphore pattern
-	for _, d := range services.Result.Data {    // currently around 20 loops --> paralleling
-		go func(d phabricator.Device) {
-			var group Group
+	for _, d := range services {                // currently around 20 loops --> paralleling
+		go func(d Service) {
+			defer func() { sem <- empty{} }()
+			group := Group{Addresses: make(map[string]string), Networks: make(map[string]string), Bindings: make(map[string]map[string]interface{}), Status: make(map[string]string)}
 			// Add the hosts from the binding
 			for _, v := range d.Attachments.Bindings.Bindings { // Anzahl Bindings: meistens zirka 1-2 --> erstmal nicht parallelisieren
 				interfaceDeviceName := v.Interface.Device.Name
-				values, _ := CreateHost(p, interfaceDeviceName) // -> one request
+				values, err := CreateHost(ctx, p, interfaceDeviceName) // -> one request
+				mutex.Lock()
+				if err != nil && partial && ctx.Err() == nil {
+					problems = append(problems, InventoryError{Device: interfaceDeviceName, Reason: err.Error(), err: err})
+				} else if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mutex.Unlock()
+					return
+				}
 				hostVars[v.Interface.Device.Name] = values
+				mutex.Unlock()
 				group.Hosts = append(group.Hosts, v.Interface.Device.Name)
+				group.Addresses[v.Interface.Device.Name] = v.Interface.Address
+				group.Networks[v.Interface.Device.Name] = v.Interface.Network.Name
+				group.Bindings[v.Interface.Device.Name] = bindingVars(v)
+				if v.Disabled {
+					group.Status[v.Interface.Device.Name] = statusDisabled
+				}
 			}
 
 			vars := make(map[string]interface{})
@@ -587,9 +754,9 @@ func ListParallel(p *phabricator.Phabricator, playBookPath string, vagrant strin
 			if len(group.Hosts) == 0 {
 				group.Hosts = []string{}
 			}
+			mutex.Lock()
 			groupList[d.Fields.Name] = group
-
-			sem <- empty{}
+			mutex.Unlock()
 		}(d)
 	}
 
@@ -597,42 +764,51 @@ func ListParallel(p *phabricator.Phabricator, playBookPath string, vagrant strin
 	for i := 0; i < amountOfResultData; i++ {
 		<-sem
 	}
+	if firstErr != nil {
+		return output, firstErr
+	}
 
+	logger.Info("devices fetched", "count", len(hostVars))
+	output.Meta.Errors = problems
 	output.Meta.HostVars = hostVars
 	output.Group = groupList
 	// If the list is running in vagrant mode
 	if vagrant != "" {
-		playbook, err := ReadAnsiblePlayBook(playBookPath)
-		if err != nil {
-			panic(err)
-		}
-		output.AddHost(vagrant, playbook[0].Host)
+		err = output.AddVagrantHost(playBookPath, vagrant, nil)
 	}
 
 	return output, err
 }
 
-func ListBlocking(p *phabricator.Phabricator, playBookPath string, vagrant string) (output Output, err error) {
+func ListBlocking(ctx context.Context, p *Conduit, playBookPath string, vagrant string, partial bool) (output Output, err error) {
 
 	groupList := make(map[string]Group)
 	hostVars := make(map[string]map[string]interface{})
-	services, err := p.GetServicesAsync()
+	services, err := p.GetServices(ctx)
 
 	// Returns the List of services
 	if err != nil {
-		panic(err)
+		return output, err
 	}
-	for _, d := range services.Result.Data {
-		var group Group
+	for _, d := range services {
+		group := Group{Addresses: make(map[string]string), Networks: make(map[string]string), Bindings: make(map[string]map[string]interface{}), Status: make(map[string]string)}
 		// Add the hosts from the binding
 		for _, v := range d.Attachments.Bindings.Bindings {
 			interfaceDeviceName := v.Interface.Device.Name
-			values, err := CreateHost(p, interfaceDeviceName)
-			if err != nil {
-				panic(err)
+			values, err := CreateHost(ctx, p, interfaceDeviceName)
+			if err != nil && partial && ctx.Err() == nil {
+				output.Meta.Errors = append(output.Meta.Errors, InventoryError{Device: interfaceDeviceName, Reason: err.Error(), err: err})
+			} else if err != nil {
+				return output, err
 			}
 			hostVars[v.Interface.Device.Name] = values
 			group.Hosts = append(group.Hosts, v.Interface.Device.Name)
+			group.Addresses[v.Interface.Device.Name] = v.Interface.Address
+			group.Networks[v.Interface.Device.Name] = v.Interface.Network.Name
+			group.Bindings[v.Interface.Device.Name] = bindingVars(v)
+			if v.Disabled {
+				group.Status[v.Interface.Device.Name] = statusDisabled
+			}
 		}
 
 		vars := make(map[string]interface{})
@@ -648,22 +824,19 @@ func ListBlocking(p *phabricator.Phabricator, playBookPath string, vagrant strin
 		}
 		groupList[d.Fields.Name] = group
 	}
+	logger.Info("devices fetched", "count", len(hostVars))
 	output.Meta.HostVars = hostVars
 	output.Group = groupList
 	// If the list is running in vagrant mode
 	if vagrant != "" {
-		playbook, err := ReadAnsiblePlayBook(playBookPath)
-		if err != nil {
-			panic(err)
-		}
-		output.AddHost(vagrant, playbook[0].Host)
+		err = output.AddVagrantHost(playBookPath, vagrant, nil)
 	}
 
 	return output, err
 }
 
-func List(p *phabricator.Phabricator, playBookPath string, vagrant string) (output Output, err error) {
-	return ListParallel(p, playBookPath, vagrant)
+func List(ctx context.Context, p *Conduit, playBookPath string, vagrant string, partial bool) (output Output, err error) {
+	return ListParallel(ctx, p, playBookPath, vagrant, partial)
 }
 
 // ReplaceToUnderscore simply replaces the dashes in the given text to underscore for the given keys.
@@ -679,16 +852,16 @@ func ReplaceDotsToUnderscore(key string) string {
 }
 
 // CreateHost Creates the host for the given device name
-func CreateHost(p *phabricator.Phabricator, devName string) (values map[string]interface{}, err error) {
+func CreateHost(ctx context.Context, p *Conduit, devName string) (values map[string]interface{}, err error) {
 	values = make(map[string]interface{})
 
-	device, err := p.GetDeviceAsync(devName) // -> one request
+	devices, err := p.GetDevice(ctx, devName) // -> one request
 	if err != nil {
-		panic(err)
+		return values, err
 	}
 
 	// Collect the properties
-	for _, v := range device.Result.Data {
+	for _, v := range devices {
 		for _, i := range v.Attachments.Properties.Properties {
 			key := ReplaceToUnderscore(i.Key)
 			values[key] = i.Value
@@ -698,111 +871,54 @@ func CreateHost(p *phabricator.Phabricator, devName string) (values map[string]i
 	return values, err
 }
 
-// ReadAnsiblePlayBook reads the given playbook from path and decode it to AnsiblePlaybook.
-func ReadAnsiblePlayBook(path string) (playbook []AnsiblePlaybook, err error) {
-	buffer, err := ioutil.ReadFile(path)
-	if err != nil {
-		panic(err)
+// CreateConduit creates the Conduit client with the timeouts and retries from the configuration.
+func CreateConduit(Config Configuration) (p *Conduit, err error) {
+	p = NewConduit(Config.Phabricator.ApiURL, Config.Phabricator.ApiToken)
+	p.Retries = Config.Phabricator.Retries
+	if Config.Phabricator.RequestTimeout != "" {
+		p.RequestTimeout, err = time.ParseDuration(Config.Phabricator.RequestTimeout)
+		if err != nil {
+			return p, NewError(ConfigError, err, "invalid Phabricator.RequestTimeout")
+		}
 	}
-	err = yaml.Unmarshal(buffer, &playbook)
-
-	return playbook, err
+	if Config.Phabricator.Backoff != "" {
+		p.Backoff, err = time.ParseDuration(Config.Phabricator.Backoff)
+		if err != nil {
+			return p, NewError(ConfigError, err, "invalid Phabricator.Backoff")
+		}
+	}
+	return p, err
 }
 
-// GetConfigPaths returns the list of config paths for the given system
-func GetConfigPaths() []string {
-	usr, err := user.Current()
+// CreateContext returns the context for a run. It is cancelled with Ctrl-C and after
+// the overall timeout, if one is given.
+func CreateContext(timeout string) (ctx context.Context, cancel context.CancelFunc, err error) {
+	ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt)
+	if timeout == "" {
+		return ctx, cancel, err
+	}
+	duration, err := time.ParseDuration(timeout)
 	if err != nil {
-		return []string{"/etc/a2a/config", "config"}
+		return ctx, cancel, NewError(ConfigError, err, "invalid timeout")
 	}
-	homePath := usr.HomeDir + "/.a2a/config"
-	return []string{"/etc/a2a/config", homePath, "config"}
+	ctx, cancelTimeout := context.WithTimeout(ctx, duration)
+	return ctx, func() {
+		cancelTimeout()
+		cancel()
+	}, err
 }
 
-// ReadConfig reads the configuration from the configurations
-func ReadConfig() (Config Configuration, err error) {
-	found := false
-	for _, v := range GetConfigPaths() {
-		if found == false {
-			err := gcfg.ReadFileInto(&Config, v)
-			if err == nil {
-				found = true
-			}
-		}
-	}
-	if found == false {
-		return Config, errors.New("the configuration is not found in one of /etc/a2a/config, ~/.a2a/config or config or there was an error reading the config file")
-	}
-	return Config, err
-}
-
-// CreateCommandLine creates a command line for the application
-func CreateCommandLine() *cli.App {
-	app := cli.NewApp()
-	app.Version = "0.0.14"
-	app.Author = "Pouyan Azari"
-	app.EnableBashCompletion = true
-	app.Name = "A2A"
-	app.Usage = "Almanac2Ansible helps you to use your Almanac inventory as Ansible dynamic inventory"
-	app.Flags = []cli.Flag{
-		cli.StringFlag{
-			Name:  "vagrant, a",
-			Usage: "Vagrant mode which needs the name of the host to be added to the given service",
-		},
-		cli.BoolFlag{
-			Name:  "list, l",
-			Usage: "Lists the Services and Hosts in a way readable by Ansible.",
-		},
-		cli.StringFlag{
-			Name:  "host, s",
-			Usage: "List the properties for the given host",
-		},
-		cli.StringFlag{
-			Name: "alertmanager, m",
-			Usage: "Returns the alert manager settings, " +
-				"it reads the existing file and adds the needed data to the alerts",
-		},
-		cli.BoolFlag{
-			Name:  "blackbox, b",
-			Usage: "Returns the list of services that support the blackbox",
-		},
-		cli.BoolFlag{
-			Name:  "prometheus, p",
-			Usage: "Returns the list of services supported by Prometheus for the given host",
-		},
-		cli.StringFlag{
-			Name:  "ignore, i",
-			Usage: "Make the Prometheus or blackbox exporter, ignore the given group",
-		},
-		cli.BoolFlag{
-			Name:  "no-cache, n",
-			Usage: "Run the application in no cache mode",
-		},
-	}
-
-	return app
-}
-
-// AugmentHost augments the given host with th
-func AugmentHost(p *phabricator.Phabricator, hostData map[string]interface{}, PassphraseWrapper string, JsonWrapper string) map[string]interface{} {
-	for k, v := range hostData {
-		passphrase, isPassphrase, _ := HandlePassphrase(p, PassphraseWrapper, v.(string))
-		if isPassphrase {
-			hostData[k] = passphrase
-		}
-		jsonData, isJson, _ := HandleJson(JsonWrapper, v.(string))
-		if isJson {
-			hostData[k] = jsonData
-		}
-	}
-	return hostData
+// AugmentHost augments the given host with the passphrases and json values.
+// The properties that can not be resolved are removed and returned as problems.
+func AugmentHost(ctx context.Context, p *Conduit, hostData map[string]interface{}, PassphraseWrapper string, JsonWrapper string) (map[string]interface{}, []InventoryError) {
+	problems := augmentVars(ctx, p, PassphraseWrapper, JsonWrapper, hostData)
+	return hostData, problems
 }
 
 // Returns the path to the temporary file
 // It uses the tmp path that used by the OS per default
 func getTempFilePath(fileName string) (file *os.File, err error) {
-	filePathGlob, err := filepath.Abs(os.TempDir() + fileName + "*")
-	matches, err := filepath.Glob(filePathGlob)
+	matches, err := getCacheFiles(fileName)
 	if err != nil {
 		file, err = ioutil.TempFile(os.TempDir(), fileName)
 		return file, err
@@ -818,89 +934,33 @@ func getTempFilePath(fileName string) (file *os.File, err error) {
 	return file, err
 }
 
-// Main Application
-func main() {
-	// Read the configuration
-	Config, err := ReadConfig()
+// getCacheFiles returns the cache files with the given name in the temp folder. The random
+// part of the temp files are digits, so the caches of other namespaces are not matched.
+func getCacheFiles(fileName string) ([]string, error) {
+	return filepath.Glob(filepath.Join(os.TempDir(), fileName+"[0-9]*"))
+}
+
+// cacheModTime returns the time the cache with the given name was written, found is false without a cache.
+func cacheModTime(fileName string) (modTime time.Time, found bool) {
+	matches, err := getCacheFiles(fileName)
+	if err != nil || len(matches) != 1 {
+		return modTime, false
+	}
+	info, err := os.Stat(matches[0])
 	if err != nil {
-		panic(err)
+		return modTime, false
 	}
-	p := phabricator.NewPhabricator(Config.Phabricator.ApiURL, Config.Phabricator.ApiToken)
+	return info.ModTime(), true
+}
+
+// getAllCacheFiles returns the cache files of all the namespaces.
+func getAllCacheFiles() ([]string, error) {
+	return filepath.Glob(filepath.Join(os.TempDir(), cacheFile+"*"))
+}
+
+// Main Application
+func main() {
 	app := CreateCommandLine()
-	app.Action = func(c *cli.Context) error {
-		// Check if the vagrant mode is on
-		vagrant := c.String("vagrant")
-		listIsOn := c.Bool("list")
-		prometheusIsOn := c.Bool("prometheus")
-		blackBoxIsOn := c.Bool("blackbox")
-		cacheIsOff := c.Bool("no-cache")
-		ignoreGroups := c.String("ignore")
-		if vagrant != "" {
-			cacheIsOff = true
-		}
-		// Manage the --list command
-		if listIsOn {
-			cachedData, cacheStatus, err := readCache(cacheFile, 10)
-			if cacheStatus && !cacheIsOff {
-				if err != nil {
-					panic(err)
-				}
-				fmt.Print(string(cachedData))
-				return nil
-			}
-			list, err := List(p, Config.Ansible.Playbook, vagrant)
-			if err != nil {
-				panic(err)
-			}
-			list.Augment(p, Config.Wrapper.Passphrase, Config.Wrapper.Json)
-			printedData := list.Sanitize()
-			jsonData, err := json.Marshal(printedData)
-			saveCache(jsonData, cacheFile)
-			fmt.Print(string(jsonData))
-		}
-		// Creates the blackbox settings with modules as labels.
-		// Should use relabeling to make parameter from the label.
-		if blackBoxIsOn {
-			var ignoreArray []string
-			if ignoreGroups != "" {
-				ignoreArray = strings.Split(ignoreGroups, ",")
-			}
-			blackBoxData, err := GetBlackBoxData(p, Config.Wrapper.Json, ignoreArray)
-			if err == nil {
-				jsonData, _ := json.Marshal(blackBoxData)
-				fmt.Println(string(jsonData))
-			}
-		}
-		// Creates the prometheus dynamic scraps from the Almanac repo
-		// --prometheus
-		if prometheusIsOn {
-			var ignoreArray []string
-			if ignoreGroups != "" {
-				ignoreArray = strings.Split(ignoreGroups, ",")
-			}
-			prometheusData, err := GetPrometheusData(p, Config.Wrapper.Json, ignoreArray)
-			if err == nil {
-				jsonData, _ := json.Marshal(prometheusData)
-				fmt.Println(string(jsonData))
-			}
-		}
-		// Manages the alertmanager command
-		// Reads the alertManager configs and rewrites with new routes.
-		// --alertmanager
-		alertManagerConfigPath := c.String("alertmanager")
-		if alertManagerConfigPath != "" {
-			manageAlertManager(p, alertManagerConfigPath, Config.Wrapper.Json)
-		}
-		// Manage the --host command
-		host := c.String("host")
-		if host != "" {
-			hostData, _ := CreateHost(p, host)
-			hostData = AugmentHost(p, hostData, Config.Wrapper.Passphrase, Config.Wrapper.Json)
-			jsonData, _ := json.Marshal(hostData)
-
-			fmt.Print(string(jsonData))
-		}
-		return nil
-	}
-	err = app.Run(os.Args)
+	err := app.Run(os.Args)
+	exitWithError(err, debugMode)
 }
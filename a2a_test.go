@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"github.com/uniwue-rz/phabricator-go"
 	"testing"
 )
 
@@ -22,14 +22,18 @@ func TestListWithAugmentParallel(t *testing.T)  {
 		panic(err)
 	}
 
-	p := phabricator.NewPhabricator(Config.Phabricator.ApiURL, Config.Phabricator.ApiToken)
+	p, err := CreateConduit(Config)
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
 	vagrant := ""
-	list, err := ListParallel(p, Config.Ansible.Playbook, vagrant)
+	list, err := ListParallel(ctx, p, Config.Ansible.Playbook, vagrant, false)
 	if err != nil {
 		panic(err)
 	}
 
-	list.AugmentParallel(p, Config.Wrapper.Passphrase, Config.Wrapper.Json)
+	list.AugmentParallel(ctx, p, Config.Wrapper.Passphrase, Config.Wrapper.Json)
 	printedData := list.Sanitize()
 	_, err = json.Marshal(printedData)
 }
@@ -40,14 +44,18 @@ func TestListWithAugmentBlocking(t *testing.T)  {
 		panic(err)
 	}
 
-	p := phabricator.NewPhabricator(Config.Phabricator.ApiURL, Config.Phabricator.ApiToken)
+	p, err := CreateConduit(Config)
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
 	vagrant := ""
-	list, err := ListBlocking(p, Config.Ansible.Playbook, vagrant)
+	list, err := ListBlocking(ctx, p, Config.Ansible.Playbook, vagrant, false)
 	if err != nil {
 		panic(err)
 	}
 
-	list.AugmentBlocking(p, Config.Wrapper.Passphrase, Config.Wrapper.Json)
+	list.AugmentBlocking(ctx, p, Config.Wrapper.Passphrase, Config.Wrapper.Json)
 	printedData := list.Sanitize()
 	_, err = json.Marshal(printedData)
 }
\ No newline at end of file
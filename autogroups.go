@@ -0,0 +1,107 @@
+package main
+
+// The automatic groups are derived from Almanac itself: a group for every network of the
+// bindings, for every project tag of the devices and for the namespace of every device. They
+// are switched on in the [AutoGroups] section of the configuration.
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// AddAutoGroups adds the automatic groups of the configuration to the inventory. The projects
+// and the namespaces are fetched from Phabricator, the networks are known from the bindings.
+func (output *Output) AddAutoGroups(ctx context.Context, p *Conduit, Config Configuration) error {
+	options := Config.AutoGroups
+	if options.Network {
+		output.addNetworkGroups(options.NetworkPrefix)
+	}
+	hosts := sortedKeys(output.Meta.HostVars)
+	if options.Project && len(hosts) > 0 {
+		err := output.addProjectGroups(ctx, p, hosts, options.ProjectPrefix)
+		if err != nil {
+			return err
+		}
+	}
+	if options.Namespace && len(hosts) > 0 {
+		namespaces, err := p.GetNamespaces(ctx)
+		if err != nil {
+			return err
+		}
+		output.addNamespaceGroups(hosts, namespaces, options.NamespacePrefix)
+	}
+	return nil
+}
+
+// addNetworkGroups adds the hosts to the groups of the networks of their bindings.
+func (output *Output) addNetworkGroups(prefix string) {
+	for _, name := range output.GroupNames() {
+		networks := output.Group[name].Networks
+		for _, host := range sortedKeys(networks) {
+			if networks[host] != "" {
+				output.AddHost(host, autoGroupName(prefix, networks[host]))
+			}
+		}
+	}
+}
+
+// addProjectGroups adds the hosts to the groups of the project tags of their devices. The
+// slug of a project is used as name, the name of the project when it has no slug.
+func (output *Output) addProjectGroups(ctx context.Context, p *Conduit, hosts []string, prefix string) error {
+	devices, err := p.GetDevices(ctx, hosts, map[string]bool{"projects": true})
+	if err != nil {
+		return err
+	}
+	var phids []string
+	for _, device := range devices {
+		for _, phid := range device.Attachments.Projects.ProjectPHIDs {
+			if !containsString(phids, phid) {
+				phids = append(phids, phid)
+			}
+		}
+	}
+	projects, err := p.GetProjects(ctx, phids)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	for _, project := range projects {
+		names[project.PHID] = project.Fields.Slug
+		if project.Fields.Slug == "" {
+			names[project.PHID] = project.Fields.Name
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Fields.Name < devices[j].Fields.Name })
+	for _, device := range devices {
+		for _, phid := range device.Attachments.Projects.ProjectPHIDs {
+			if name, found := names[phid]; found {
+				output.AddHost(device.Fields.Name, autoGroupName(prefix, name))
+			}
+		}
+	}
+	return nil
+}
+
+// addNamespaceGroups adds every host to the group of its namespace, which is the longest
+// namespace that ends its name, ex. dmz.example.com for web01.dmz.example.com.
+func (output *Output) addNamespaceGroups(hosts []string, namespaces []Namespace, prefix string) {
+	for _, host := range hosts {
+		var found string
+		for _, namespace := range namespaces {
+			name := namespace.Fields.Name
+			if (host == name || strings.HasSuffix(host, "."+name)) && len(name) > len(found) {
+				found = name
+			}
+		}
+		if found != "" {
+			output.AddHost(host, autoGroupName(prefix, found))
+		}
+	}
+}
+
+// autoGroupName returns the name of an automatic group, the characters not allowed in group
+// names are replaced with underscores.
+func autoGroupName(prefix string, name string) string {
+	return unsafeGroupChars.ReplaceAllString(prefix+strings.ToLower(name), "_")
+}
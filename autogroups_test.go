@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddAutoGroups(t *testing.T) {
+	responses := map[string]string{
+		"almanac.device.search":    `[{"fields":{"name":"web01.dmz.example.com"},"attachments":{"projects":{"projectPHIDs":["PHID-PROJ-1"]}}},{"fields":{"name":"db01.example.com"},"attachments":{"projects":{"projectPHIDs":["PHID-PROJ-1","PHID-PROJ-2"]}}}]`,
+		"project.search":           `[{"phid":"PHID-PROJ-1","fields":{"name":"Web Team","slug":"webteam"}},{"phid":"PHID-PROJ-2","fields":{"name":"Databases"}}]`,
+		"almanac.namespace.search": `[{"fields":{"name":"example.com"}},{"fields":{"name":"dmz.example.com"}}]`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := responses[strings.TrimPrefix(r.URL.Path, "/")]
+		w.Write([]byte(`{"result":{"data":` + data + `,"cursor":{"after":null}},"error_code":null,"error_info":null}`))
+	}))
+	defer server.Close()
+
+	var output Output
+	output.Group = map[string]Group{"web": {
+		Hosts:    []string{"web01.dmz.example.com", "db01.example.com"},
+		Networks: map[string]string{"web01.dmz.example.com": "DMZ", "db01.example.com": ""},
+	}}
+	output.Meta.HostVars = map[string]map[string]interface{}{"web01.dmz.example.com": {}, "db01.example.com": {}}
+	Config := defaultConfig()
+	Config.AutoGroups.Network = true
+	Config.AutoGroups.Project = true
+	Config.AutoGroups.Namespace = true
+	Config.AutoGroups.ProjectPrefix = "tag_"
+	err := output.AddAutoGroups(context.Background(), NewConduit(server.URL, "api-token"), Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"net_dmz":            {"web01.dmz.example.com"},
+		"tag_webteam":        {"db01.example.com", "web01.dmz.example.com"},
+		"tag_databases":      {"db01.example.com"},
+		"ns_dmz_example_com": {"web01.dmz.example.com"},
+		"ns_example_com":     {"db01.example.com"},
+		"web":                {"web01.dmz.example.com", "db01.example.com"},
+	}
+	if !reflect.DeepEqual(output.GroupNames(), sortedKeys(expected)) {
+		t.Errorf("unexpected groups %v", output.GroupNames())
+	}
+	for group, hosts := range expected {
+		if !reflect.DeepEqual(output.Group[group].Hosts, hosts) {
+			t.Errorf("expected %v in %s, got %v", hosts, group, output.Group[group].Hosts)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// The properties of an Almanac binding describe the role of a device in one service, ex.
+// replica-id or primary. They are added to the host variables below a2a_bindings.<group>,
+// so a device can have other values in every service.
+
+import (
+	"reflect"
+)
+
+// bindingsVar is the host variable with the binding properties of the groups of the host.
+const bindingsVar = "a2a_bindings"
+
+// bindingVars returns the properties of the binding with the keys used for the host variables.
+func bindingVars(binding Binding) map[string]interface{} {
+	vars := make(map[string]interface{})
+	for _, property := range binding.Properties {
+		vars[ReplaceToUnderscore(property.Key)] = property.Value
+	}
+	return vars
+}
+
+// AddBindingVars adds the binding properties to the host variables. The groups are named like
+// in the output, with the given prefix and sanitized. With flatten the properties are added to
+// the host variables directly, when two bindings of a host disagree the last group wins.
+func (output *Output) AddBindingVars(prefix string, flatten bool) {
+	for _, name := range output.GroupNames() {
+		group := output.Group[name]
+		groupName := sanitizeGroupName(prefix + name)
+		for _, host := range sortedKeys(group.Bindings) {
+			properties := group.Bindings[host]
+			vars := output.Meta.HostVars[host]
+			if len(properties) == 0 || vars == nil {
+				continue
+			}
+			if !flatten {
+				bindings, _ := vars[bindingsVar].(map[string]interface{})
+				if bindings == nil {
+					bindings = make(map[string]interface{})
+					vars[bindingsVar] = bindings
+				}
+				bindings[groupName] = properties
+				continue
+			}
+			for key, value := range properties {
+				if current, found := vars[key]; found && !reflect.DeepEqual(current, value) {
+					logger.Warn("the binding property overrides a host variable", "host", host, "group", name, "property", key)
+				}
+				vars[key] = value
+			}
+		}
+	}
+}
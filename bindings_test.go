@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// testBindingOutput returns an inventory where db01 has other binding properties in every service.
+func testBindingOutput() Output {
+	var output Output
+	output.Group = map[string]Group{
+		"mysql-servers": {Hosts: []string{"db01"}, Bindings: map[string]map[string]interface{}{
+			"db01": bindingVars(Binding{Properties: []Property{{Key: "replica-id", Value: "2"}, {Key: "primary", Value: "false"}}}),
+		}},
+		"backup": {Hosts: []string{"db01"}, Bindings: map[string]map[string]interface{}{
+			"db01": bindingVars(Binding{Properties: []Property{{Key: "primary", Value: "true"}}}),
+		}},
+	}
+	output.Meta.HostVars = map[string]map[string]interface{}{"db01": {"os": "Debian"}}
+	return output
+}
+
+func TestAddBindingVars(t *testing.T) {
+	output := testBindingOutput()
+	output.AddBindingVars("inst-", false)
+	expected := map[string]interface{}{
+		"inst_mysql_servers": map[string]interface{}{"replica_id": "2", "primary": "false"},
+		"inst_backup":        map[string]interface{}{"primary": "true"},
+	}
+	if !reflect.DeepEqual(output.Meta.HostVars["db01"][bindingsVar], expected) {
+		t.Errorf("unexpected binding variables %v", output.Meta.HostVars["db01"][bindingsVar])
+	}
+	if _, found := output.Meta.HostVars["db01"]["primary"]; found {
+		t.Error("the binding properties should not be flattened")
+	}
+}
+
+func TestAddBindingVarsFlatten(t *testing.T) {
+	output := testBindingOutput()
+	output.AddBindingVars("", true)
+	expected := map[string]interface{}{"os": "Debian", "replica_id": "2", "primary": "false"}
+	if !reflect.DeepEqual(output.Meta.HostVars["db01"], expected) {
+		t.Errorf("unexpected host variables %v", output.Meta.HostVars["db01"])
+	}
+}
+
+func TestAugmentBindingVars(t *testing.T) {
+	output := testBindingOutput()
+	output.Group["backup"].Bindings["db01"]["targets"] = `["a", "b"]`
+	output.Group["backup"].Bindings["db01"]["broken"] = `{"a":}`
+	output.AddBindingVars("", false)
+	problems := output.AugmentBlocking(context.Background(), nil, testPassphraseWrapper, testJsonWrapper)
+	if len(problems) != 1 || problems[0].Property != "a2a_bindings.backup.broken" {
+		t.Errorf("expected a problem for the broken binding property, got %v", problems)
+	}
+	backup := output.Meta.HostVars["db01"][bindingsVar].(map[string]interface{})["backup"].(map[string]interface{})
+	if !reflect.DeepEqual(backup["targets"], []interface{}{"a", "b"}) {
+		t.Errorf("expected the json binding property to be decoded, got %v", backup["targets"])
+	}
+}
@@ -0,0 +1,1342 @@
+package main
+
+// The command line of the application. --list and --host stay on the top level, as
+// Ansible calls the inventory script with them. All the other modes are subcommands
+// with their own flags, so only one mode runs in every call.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// debugMode is set with --debug and adds the details to the error messages.
+var debugMode = false
+
+// Env is the environment of a command: the configuration, the Conduit client and
+// the context which is cancelled with Ctrl-C or after the timeout.
+type Env struct {
+	Config  Configuration
+	Conduit *Conduit
+	// Sources are the merged profiles, it is empty when only one instance is used.
+	Sources []InventorySource
+	Merge   MergeOptions
+	Context context.Context
+	cancel  context.CancelFunc
+}
+
+// NewEnv reads the configuration and creates the Conduit client and the context for a command.
+func NewEnv(c *cli.Context) (env *Env, err error) {
+	env = &Env{}
+	env.Config, err = ReadConfig()
+	if err != nil {
+		return env, err
+	}
+	if env.Config.Log.Format != "" && !lookupBool(c, "log-json") {
+		if env.Config.Log.Format != LogFormatText && env.Config.Log.Format != LogFormatJson {
+			return env, NewError(ConfigError, nil, "invalid Log.Format %q, use text or json", env.Config.Log.Format)
+		}
+		SetupLogging(lookupBool(c, "verbose"), lookupBool(c, "trace"), env.Config.Log.Format)
+	}
+	env.Conduit, err = CreateConduit(env.Config)
+	if err != nil {
+		return env, err
+	}
+	env.Merge, err = GetMergeOptions(c, env.Config)
+	if err != nil {
+		return env, err
+	}
+	if len(env.Merge.Profiles) > 0 {
+		env.Sources, err = LoadSources(env.Merge)
+		if err != nil {
+			return env, err
+		}
+		// The merged inventory has its own cache.
+		env.Config.Cache.Namespace = strings.Join(env.Merge.Profiles, "+")
+	}
+	timeout := lookupString(c, "timeout")
+	if timeout == "" {
+		timeout = env.Config.Phabricator.Timeout
+	}
+	env.Context, env.cancel, err = CreateContext(timeout)
+	return env, err
+}
+
+// List returns the inventory of the configured instance or, when profiles are merged, the
+// merged inventory. The problems are listed in _meta.a2a_errors.
+func (env *Env) List(partial bool, augment bool) (output Output, err error) {
+	if len(env.Sources) > 0 {
+		return ListSources(env.Context, env.Sources, env.Merge, partial, augment)
+	}
+	return ListSource(env.Context, InventorySource{Config: env.Config, Conduit: env.Conduit}, partial, augment)
+}
+
+// Close releases the context of the environment.
+func (env *Env) Close() {
+	if env.cancel != nil {
+		env.cancel()
+	}
+}
+
+// InventoryOptions are the flags of the inventory commands merged with the configuration.
+type InventoryOptions struct {
+	Vagrant string
+	// VagrantGroups replace the groups of the playbook in the Vagrant mode.
+	VagrantGroups []string
+	// VagrantMapping is the mapping file with the machines of the Vagrant mode.
+	VagrantMapping string
+	NoCache        bool
+	NoOverlay      bool
+	Partial        bool
+	MaxErrors      int
+	Broken         string
+}
+
+// GetInventoryOptions returns the inventory options from the flags and the configuration.
+func GetInventoryOptions(c *cli.Context, Config Configuration) (options InventoryOptions, err error) {
+	options.Vagrant = lookupString(c, "vagrant")
+	options.VagrantMapping = Config.Vagrant.Mapping
+	if mapping := lookupString(c, "vagrant-mapping"); mapping != "" {
+		options.VagrantMapping = mapping
+	}
+	options.VagrantGroups = splitGroups(lookupString(c, "vagrant-group"))
+	if len(options.VagrantGroups) > 0 && options.Vagrant == "" {
+		return options, NewError(ConfigError, nil, "--vagrant-group can only be used with --vagrant")
+	}
+	options.NoOverlay = lookupBool(c, "no-overlay")
+	// The cache contains the overlays, so it is not used without them.
+	options.NoCache = lookupBool(c, "no-cache") || options.Vagrant != "" || options.VagrantMapping != "" || options.NoOverlay
+	options.Partial = lookupBool(c, "partial") || Config.Inventory.Partial
+	options.MaxErrors = Config.Inventory.MaxErrors
+	if c.IsSet("max-errors") {
+		options.MaxErrors = c.Int("max-errors")
+	}
+	options.Broken = Config.Inventory.Broken
+	if broken := lookupString(c, "broken"); broken != "" {
+		options.Broken = broken
+	}
+	if options.Broken != "" && options.Broken != BrokenOmit && options.Broken != BrokenFlag {
+		return options, NewError(ConfigError, nil, "invalid value %q for broken hosts, use omit or flag", options.Broken)
+	}
+	return options, nil
+}
+
+// lookupString returns the flag of the command or, when it is not set there, of the application.
+func lookupString(c *cli.Context, name string) string {
+	if value := c.String(name); value != "" {
+		return value
+	}
+	return c.GlobalString(name)
+}
+
+// lookupBool returns true if the flag is set for the command or for the application.
+func lookupBool(c *cli.Context, name string) bool {
+	return c.Bool(name) || c.GlobalBool(name)
+}
+
+// splitGroups splits the comma separated groups of --ignore.
+func splitGroups(groups string) (groupArray []string) {
+	if groups != "" {
+		groupArray = strings.Split(groups, ",")
+	}
+	return groupArray
+}
+
+// inventoryFlags are the flags used to create the inventory.
+func inventoryFlags() []cli.Flag {
+	return append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "vagrant, a",
+			Usage: "Vagrant mode which needs the name of the host to be added to the given service",
+		},
+		cli.StringFlag{
+			Name:  "vagrant-mapping",
+			Usage: "YAML file with the machines of a multi-machine Vagrant environment, replaces Vagrant.Mapping",
+		},
+		cli.StringFlag{
+			Name:  "vagrant-group",
+			Usage: "Comma separated groups for the vagrant host, replaces the groups of the playbook",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache, n",
+			Usage: "Run the application in no cache mode",
+		},
+		noOverlayFlag,
+	}, partialFlags()...)
+}
+
+// partialFlags are the flags of the partial mode.
+func partialFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  "partial",
+			Usage: "Build the inventory even when single hosts fail, the problems are listed in _meta.a2a_errors",
+		},
+		cli.IntFlag{
+			Name:  "max-errors",
+			Usage: "The number of problems allowed in partial mode before a2a exits non-zero, any number when it is not set",
+		},
+		cli.StringFlag{
+			Name:  "broken",
+			Usage: "What happens to the hosts with problems in partial mode: omit or flag",
+		},
+	}
+}
+
+// ignoreFlag is the flag to ignore groups in the Prometheus outputs.
+var ignoreFlag = cli.StringFlag{
+	Name:  "ignore, i",
+	Usage: "Comma separated list of groups to ignore",
+}
+
+// noOverlayFlag is the flag to build the inventory without the overlays.
+var noOverlayFlag = cli.BoolFlag{
+	Name:  "no-overlay",
+	Usage: "Do not apply the overlays of Overlay.File",
+}
+
+// propertyFlags select the device or the service of the property commands.
+var propertyFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "device",
+		Usage: "The name of the Almanac device",
+	},
+	cli.StringFlag{
+		Name:  "service",
+		Usage: "The name of the Almanac service",
+	},
+}
+
+// CreateCommandLine creates a command line for the application
+func CreateCommandLine() *cli.App {
+	app := cli.NewApp()
+	app.Version = "0.0.14"
+	app.Author = "Pouyan Azari"
+	app.EnableBashCompletion = true
+	app.Name = "A2A"
+	app.Usage = "Almanac2Ansible helps you to use your Almanac inventory as Ansible dynamic inventory"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config, c",
+			Usage:  "The configuration file, replaces the default paths",
+			EnvVar: envConfig,
+		},
+		cli.StringFlag{
+			Name:  "merge",
+			Usage: "Comma separated profiles that are merged to one inventory, replaces Merge.Profile",
+		},
+		cli.StringFlag{
+			Name:   "profile",
+			Usage:  "The profile of the configuration, ex. prod for [Profile \"prod\"], the binary name a2a-prod selects it too",
+			EnvVar: envProfile,
+		},
+		cli.BoolFlag{
+			Name:  "list, l",
+			Usage: "Lists the Services and Hosts in a way readable by Ansible.",
+		},
+		cli.StringFlag{
+			Name:  "host, s",
+			Usage: "List the properties for the given host",
+		},
+		cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "Log the cache, the fetched services and devices and the resolved passphrases to stderr",
+		},
+		cli.BoolFlag{
+			Name:  "trace",
+			Usage: "Log every Conduit call with its duration to stderr",
+		},
+		cli.BoolFlag{
+			Name:  "log-json",
+			Usage: "Write the log lines as json. Overwrites Log.Format",
+		},
+		cli.BoolFlag{
+			Name:  "debug",
+			Usage: "Print the details of an error",
+		},
+		cli.StringFlag{
+			Name:  "timeout, t",
+			Usage: "The overall deadline for the Phabricator requests, ex. 2m. Overwrites Phabricator.Timeout",
+		},
+		// The old mode flags are kept, so the existing cron jobs keep working.
+		cli.StringFlag{
+			Name:  "alertmanager, m",
+			Usage: "Deprecated, use alertmanager merge",
+		},
+		cli.BoolFlag{
+			Name:  "blackbox, b",
+			Usage: "Deprecated, use prometheus blackbox",
+		},
+		cli.BoolFlag{
+			Name:  "prometheus, p",
+			Usage: "Deprecated, use prometheus sd",
+		},
+		cli.StringFlag{
+			Name:  "ignore, i",
+			Usage: "Deprecated, use --ignore of the prometheus commands",
+		},
+	}
+	app.Flags = append(app.Flags, inventoryFlags()...)
+	app.Before = func(c *cli.Context) error {
+		debugMode = c.Bool("debug")
+		configPath = c.String("config")
+		profileName = c.String("profile")
+		binaryProfile = profileFromArgs(os.Args)
+		logFormat := LogFormatText
+		if c.Bool("log-json") {
+			logFormat = LogFormatJson
+		}
+		SetupLogging(c.Bool("verbose"), c.Bool("trace"), logFormat)
+		return nil
+	}
+	app.Action = runTopLevel
+	app.Commands = []cli.Command{
+		{
+			Name:  "inventory",
+			Usage: "Ansible dynamic inventory",
+			Subcommands: []cli.Command{
+				{
+					Name:   "list",
+					Usage:  "Lists the Services and Hosts in a way readable by Ansible",
+					Flags:  inventoryFlags(),
+					Action: runList,
+				},
+				{
+					Name:      "host",
+					Usage:     "List the properties for the given host",
+					ArgsUsage: "HOST",
+					Flags:     append([]cli.Flag{noOverlayFlag}, partialFlags()...),
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return NewError(ConfigError, nil, "inventory host needs exactly one host name")
+						}
+						return runHost(c, c.Args().First())
+					},
+				},
+			},
+		},
+		{
+			Name:  "prometheus",
+			Usage: "Prometheus file based service discovery",
+			Subcommands: []cli.Command{
+				{
+					Name:   "sd",
+					Usage:  "Returns the scrape targets of the services and hosts with a prometheus-config",
+					Flags:  []cli.Flag{ignoreFlag},
+					Action: runPrometheus,
+				},
+				{
+					Name:   "blackbox",
+					Usage:  "Returns the blackbox targets of the services and hosts with a blackbox-config",
+					Flags:  []cli.Flag{ignoreFlag},
+					Action: runBlackbox,
+				},
+			},
+		},
+		{
+			Name:  "alertmanager",
+			Usage: "Prometheus alertmanager configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:      "merge",
+					Usage:     "Reads the existing alertmanager configuration and adds the routes and receivers of the services",
+					ArgsUsage: "CONFIG_FILE",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return NewError(ConfigError, nil, "alertmanager merge needs exactly one configuration file")
+						}
+						return runAlertManager(c, c.Args().First())
+					},
+				},
+			},
+		},
+		{
+			Name:  "cache",
+			Usage: "Manages the inventory cache",
+			Subcommands: []cli.Command{
+				{
+					Name:   "info",
+					Usage:  "Shows the cache files of all profiles and their age",
+					Action: runCacheInfo,
+				},
+				{
+					Name:   "clear",
+					Usage:  "Removes the cache files of all profiles",
+					Action: runCacheClear,
+				},
+			},
+		},
+		{
+			Name:  "vagrant",
+			Usage: "Vagrant environments",
+			Subcommands: []cli.Command{
+				{
+					Name:  "mapping",
+					Usage: "Creates the mapping file of the machines from vagrant ssh-config",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "ssh-config",
+							Usage: "File with the output of vagrant ssh-config, - reads stdin, runs vagrant ssh-config per default",
+						},
+						cli.StringFlag{
+							Name:  "update",
+							Usage: "Updates the host variables of the given mapping file and keeps its groups",
+						},
+					},
+					Action: runVagrantMapping,
+				},
+			},
+		},
+		{
+			Name:      "explain",
+			Usage:     "Shows where the variables of a host come from and which value wins",
+			ArgsUsage: "HOST [VAR]",
+			Flags: []cli.Flag{
+				noOverlayFlag,
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Prints the sources as json",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 || c.NArg() > 2 {
+					return NewError(ConfigError, nil, "explain needs a host name and optionally a variable")
+				}
+				return runExplain(c, c.Args().Get(0), c.Args().Get(1))
+			},
+		},
+		{
+			Name:      "diff",
+			Usage:     "Shows the hosts and variables that changed between two inventory snapshots",
+			ArgsUsage: "[SNAPSHOT_A SNAPSHOT_B]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "Compares the newest snapshot with the one from the given time ago, ex. 2d or 12h",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Prints the changes as json",
+				},
+			},
+			Action: runDiff,
+		},
+		{
+			Name:  "lint",
+			Usage: "Checks the Almanac services, bindings and devices for broken properties",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "Fails on the warnings too",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Prints the problems as json",
+				},
+			},
+			Action: runLint,
+		},
+		{
+			Name:  "host",
+			Usage: "Manages the Almanac devices of the hosts",
+			Subcommands: []cli.Command{
+				{
+					Name:      "register",
+					Usage:     "Creates the device, its interface and the service bindings of a host, existing ones are kept",
+					ArgsUsage: "NAME",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "address",
+							Usage: "The IP address or host name of the interface",
+						},
+						cli.IntFlag{
+							Name:  "port",
+							Value: 22,
+							Usage: "The port of the interface",
+						},
+						cli.StringFlag{
+							Name:  "network",
+							Usage: "The Almanac network of the interface",
+						},
+						cli.StringSliceFlag{
+							Name:  "service",
+							Usage: "The Almanac service the host is bound to, can be repeated",
+						},
+						cli.StringSliceFlag{
+							Name:  "property",
+							Usage: "A device property as key=value, can be repeated",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Prints the steps as json",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return NewError(ConfigError, nil, "host register needs the name of the host")
+						}
+						return runHostRegister(c, c.Args().Get(0))
+					},
+				},
+			},
+		},
+		{
+			Name:      "import",
+			Usage:     "Creates the services, devices, bindings and properties of a static Ansible inventory in Almanac",
+			ArgsUsage: "INVENTORY_FILE",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "network",
+					Usage: "The Almanac network of the new interfaces, replaces Import.Network",
+				},
+				cli.StringFlag{
+					Name:  "secrets-file",
+					Usage: "Lists the secrets of the inventory in this file for review, the monograms added there are set as Passphrase references",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Prints the plan without changing Almanac",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Prints the steps as json",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return NewError(ConfigError, nil, "import needs the inventory file")
+				}
+				return runImport(c, c.Args().Get(0))
+			},
+		},
+		{
+			Name:  "facts",
+			Usage: "Uses the facts gathered by Ansible",
+			Subcommands: []cli.Command{
+				{
+					Name:  "sync",
+					Usage: "Writes the cached facts of the hosts to the properties of their Almanac devices",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "directory",
+							Usage: "The fact cache of the jsonfile plugin, replaces Facts.Directory",
+						},
+						cli.BoolFlag{
+							Name:  "yes",
+							Usage: "Writes the changes without asking",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Prints the changes as json",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return runFactsSync(c)
+					},
+				},
+			},
+		},
+		{
+			Name:  "property",
+			Usage: "Reads and changes the properties of an Almanac device or service",
+			Subcommands: []cli.Command{
+				{
+					Name:      "get",
+					Usage:     "Prints the value of a property",
+					ArgsUsage: "KEY",
+					Flags:     propertyFlags,
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return NewError(ConfigError, nil, "property get needs a key")
+						}
+						return runPropertyGet(c, c.Args().Get(0))
+					},
+				},
+				{
+					Name:      "set",
+					Usage:     "Sets a property, - reads the value from stdin",
+					ArgsUsage: "KEY VALUE",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name: "passphrase-ref",
+							Usage: "Writes the reference to the existing Passphrase credential with this monogram, ex. K42, instead of a value",
+						},
+					}, propertyFlags...),
+					Action: func(c *cli.Context) error {
+						if c.String("passphrase-ref") != "" {
+							if c.NArg() != 1 {
+								return NewError(ConfigError, nil, "property set --passphrase-ref needs only a key")
+							}
+							return runPropertySet(c, c.Args().Get(0), "")
+						}
+						if c.NArg() != 2 {
+							return NewError(ConfigError, nil, "property set needs a key and a value")
+						}
+						return runPropertySet(c, c.Args().Get(0), c.Args().Get(1))
+					},
+				},
+				{
+					Name:      "unset",
+					Usage:     "Removes a property",
+					ArgsUsage: "KEY",
+					Flags:     propertyFlags,
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return NewError(ConfigError, nil, "property unset needs a key")
+						}
+						return runPropertyUnset(c, c.Args().Get(0))
+					},
+				},
+			},
+		},
+		{
+			Name:      "schema",
+			Usage:     "Prints the JSON Schema of a special property",
+			ArgsUsage: "PROPERTY",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return NewError(ConfigError, nil, "schema needs one of %s", strings.Join(SchemaNames(), ", "))
+				}
+				source, err := PropertySchema(c.Args().Get(0))
+				if err != nil {
+					return err
+				}
+				fmt.Println(source)
+				return nil
+			},
+		},
+		{
+			Name:  "doctor",
+			Usage: "Checks the configuration, the API token, the Almanac access, the cache and the playbook",
+			Action: func(c *cli.Context) error {
+				return PrintChecks(os.Stdout, RunDoctor(lookupString(c, "timeout")))
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "Shows the configuration of a2a",
+			Subcommands: []cli.Command{
+				{
+					Name:   "paths",
+					Usage:  "Lists the configuration paths and which one is used",
+					Action: runConfigPaths,
+				},
+				{
+					Name:  "show",
+					Usage: "Prints the effective configuration with the source of every value, the API token is masked",
+					Action: func(c *cli.Context) error {
+						Config, sources, err := LoadConfig()
+						if err != nil {
+							return err
+						}
+						PrintConfig(Config, sources)
+						return nil
+					},
+				},
+			},
+		},
+	}
+
+	return app
+}
+
+// runTopLevel runs the mode selected with the top level flags. Only one mode can be used.
+func runTopLevel(c *cli.Context) error {
+	modes := 0
+	for _, isOn := range []bool{c.Bool("list"), c.String("host") != "", c.Bool("prometheus"),
+		c.Bool("blackbox"), c.String("alertmanager") != ""} {
+		if isOn {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return NewError(ConfigError, nil, "only one of --list, --host, --prometheus, --blackbox or --alertmanager can be used")
+	}
+	switch {
+	case c.Bool("list"):
+		return runList(c)
+	case c.String("host") != "":
+		return runHost(c, c.String("host"))
+	case c.Bool("prometheus"):
+		return runPrometheus(c)
+	case c.Bool("blackbox"):
+		return runBlackbox(c)
+	case c.String("alertmanager") != "":
+		return runAlertManager(c, c.String("alertmanager"))
+	}
+	return cli.ShowAppHelp(c)
+}
+
+// runList prints the inventory for Ansible
+func runList(c *cli.Context) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	Config := env.Config
+	options, err := GetInventoryOptions(c, Config)
+	if err != nil {
+		return err
+	}
+	status, err := GetStatusOptions(Config, "inventory")
+	if err != nil {
+		return err
+	}
+	cache := cacheName(Config)
+	cachedData, cacheStatus, err := readCache(cache, 10)
+	if cacheStatus && !options.NoCache {
+		// The overlays are in the cache, an overlay changed after it was written makes it stale.
+		if cached, found := cacheModTime(cache); found {
+			if changed := changedOverlay(Config.Overlay.File, cached); changed != "" {
+				logger.Info("the overlay is newer than the cache", "file", changed)
+				cacheStatus = false
+			}
+		}
+	}
+	if cacheStatus && !options.NoCache {
+		if err != nil {
+			return NewError(OutputError, err, "can not read the cache")
+		}
+		logger.Info("cache hit", "file", cache)
+		fmt.Print(string(cachedData))
+		return nil
+	}
+	logger.Info("cache miss", "file", cache, "disabled", options.NoCache)
+	list, err := env.Inventory(options, status)
+	if err != nil {
+		return err
+	}
+	err = CheckProblems(list.Meta.Errors, options.Partial, options.MaxErrors)
+	if err != nil && !options.Partial {
+		return err
+	}
+	list.HandleBroken(options.Broken == BrokenFlag)
+	printedData := list.Sanitize()
+	jsonData, jsonErr := json.Marshal(printedData)
+	if jsonErr != nil {
+		return NewError(OutputError, jsonErr, "can not encode the inventory")
+	}
+	// The inventory with problems is not cached, so the next run tries again.
+	if len(list.Meta.Errors) == 0 {
+		cacheErr := saveCache(jsonData, cache)
+		if cacheErr != nil {
+			return cacheErr
+		}
+		if Config.Snapshot.Directory != "" {
+			saved, snapshotErr := SaveSnapshot(Config.Snapshot.Directory, jsonData, Config.Snapshot.Keep, time.Now())
+			if snapshotErr != nil {
+				logger.Warn("the snapshot is not written", "error", snapshotErr)
+			} else if saved {
+				logger.Info("snapshot written", "directory", Config.Snapshot.Directory)
+			}
+		}
+	}
+	fmt.Print(string(jsonData))
+	return err
+}
+
+// runHost prints the variables of the given host for Ansible. They are taken from the whole
+// inventory, so they are the same as in _meta.hostvars of runList.
+func runHost(c *cli.Context, host string) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	options, err := GetInventoryOptions(c, env.Config)
+	if err != nil {
+		return err
+	}
+	status, err := GetStatusOptions(env.Config, "inventory")
+	if err != nil {
+		return err
+	}
+	list, err := env.Inventory(options, status)
+	if err != nil {
+		return err
+	}
+	err = CheckProblems(list.Meta.Errors, options.Partial, options.MaxErrors)
+	if err != nil && !options.Partial {
+		return err
+	}
+	list.HandleBroken(options.Broken == BrokenFlag)
+	hostData, found := list.Meta.HostVars[host]
+	if !found {
+		if err != nil {
+			return err
+		}
+		return NewError(DataError, nil, "the host %s is not in the inventory", host)
+	}
+	jsonData, jsonErr := json.Marshal(hostData)
+	if jsonErr != nil {
+		return NewError(OutputError, jsonErr, "can not encode the host %s", host)
+	}
+	fmt.Print(string(jsonData))
+	return err
+}
+
+// Inventory builds the inventory for Ansible: the status action, the Vagrant hosts, the
+// overlays, the templates and the composed variables are applied to the inventory of Almanac.
+// The problems are in list.Meta.Errors, the callers check them with CheckProblems.
+func (env *Env) Inventory(options InventoryOptions, status StatusOptions) (list Output, err error) {
+	Config := env.Config
+	list, err = env.List(options.Partial, true)
+	if err != nil {
+		return list, err
+	}
+	list.ApplyStatus(status)
+	if options.Vagrant != "" {
+		err = list.AddVagrantHost(Config.Ansible.Playbook, options.Vagrant, options.VagrantGroups)
+		if err != nil {
+			return list, err
+		}
+	}
+	if options.VagrantMapping != "" {
+		mapping, err := ReadVagrantMapping(options.VagrantMapping)
+		if err != nil {
+			return list, err
+		}
+		err = list.AddVagrantMachines(Config.Ansible.Playbook, mapping)
+		if err != nil {
+			return list, err
+		}
+	}
+	if !options.NoOverlay {
+		problems, err := list.ApplyOverlays(env.Context, env.Conduit, Config)
+		if err != nil {
+			return list, err
+		}
+		list.Meta.Errors = append(list.Meta.Errors, problems...)
+	}
+	list.Meta.Errors = append(list.Meta.Errors, list.ExpandTemplates(Config)...)
+	problems, err := list.Construct(Config)
+	if err != nil {
+		return list, err
+	}
+	list.Meta.Errors = append(list.Meta.Errors, problems...)
+	return list, nil
+}
+
+// runExplain prints the sources of the variables of the host. The inventory is read in partial
+// mode, so the problems of the host are logged instead of failing.
+func runExplain(c *cli.Context, host string, name string) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	Config := env.Config
+	if c.Bool("no-overlay") {
+		Config.Overlay.File = nil
+	}
+	list, err := env.List(true, false)
+	if err != nil {
+		return err
+	}
+	for _, problem := range list.Meta.Errors {
+		if problem.Device == host {
+			logger.Warn("problem of the host", "error", problem.Error())
+		}
+	}
+	sources, err := list.Explain(host, Config)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		sources, err = FilterSources(sources, name)
+		if err != nil {
+			return err
+		}
+	}
+	if c.Bool("json") {
+		jsonData, _ := json.Marshal(sources)
+		fmt.Println(string(jsonData))
+		return nil
+	}
+	PrintExplanation(os.Stdout, sources)
+	return nil
+}
+
+// runDiff prints the changes between two snapshots, the two newest ones per default.
+func runDiff(c *cli.Context) error {
+	Config, err := ReadConfig()
+	if err != nil {
+		return err
+	}
+	dir := Config.Snapshot.Directory
+	if dir == "" {
+		return NewError(ConfigError, nil, "Snapshot.Directory is not set")
+	}
+	var fromPath, toPath string
+	switch {
+	case c.NArg() == 2 && c.String("since") == "":
+		fromPath, err = FindSnapshot(dir, c.Args().Get(0))
+		if err == nil {
+			toPath, err = FindSnapshot(dir, c.Args().Get(1))
+		}
+	case c.NArg() == 0:
+		var age time.Duration
+		if c.String("since") != "" {
+			age, err = ParseAge(c.String("since"))
+			if err != nil {
+				return err
+			}
+		}
+		snapshots, err := ListSnapshots(dir)
+		if err != nil {
+			return err
+		}
+		from, to, err := SnapshotsSince(snapshots, age, time.Now())
+		if err != nil {
+			return err
+		}
+		fromPath, toPath = from.Path, to.Path
+	default:
+		return NewError(ConfigError, nil, "diff needs either two snapshots or --since")
+	}
+	if err != nil {
+		return err
+	}
+	from, err := ReadSnapshot(fromPath)
+	if err != nil {
+		return err
+	}
+	to, err := ReadSnapshot(toPath)
+	if err != nil {
+		return err
+	}
+	diff := DiffSnapshots(from, to)
+	if c.Bool("json") {
+		jsonData, _ := json.Marshal(diff)
+		fmt.Println(string(jsonData))
+		return nil
+	}
+	fmt.Printf("# %s -> %s\n", fromPath, toPath)
+	PrintDiff(os.Stdout, diff)
+	return nil
+}
+
+// runLint checks the Almanac data of the profile or of every merged profile.
+func runLint(c *cli.Context) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	sources := env.Sources
+	if len(sources) == 0 {
+		sources = []InventorySource{{Config: env.Config, Conduit: env.Conduit}}
+	}
+	problems := make([]LintProblem, 0)
+	for _, source := range sources {
+		sourceProblems, err := Lint(env.Context, source.Conduit, source.Config)
+		if err != nil {
+			if source.Name != "" {
+				return NewError(KindOf(err), err, "profile %s", source.Name)
+			}
+			return err
+		}
+		for _, problem := range sourceProblems {
+			problem.Source = source.Name
+			problems = append(problems, problem)
+		}
+	}
+	if c.Bool("json") {
+		jsonData, _ := json.Marshal(problems)
+		fmt.Println(string(jsonData))
+	} else {
+		PrintLint(os.Stdout, problems)
+	}
+	return CheckLint(problems, c.Bool("strict"))
+}
+
+// runImport imports the static inventory and clears the inventory cache when something changed.
+func runImport(c *cli.Context, path string) error {
+	inventory, err := ReadStaticInventory(path)
+	if err != nil {
+		return err
+	}
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	options := ImportOptions{Network: env.Config.Import.Network, DryRun: c.Bool("dry-run"), SecretsFile: c.String("secrets-file")}
+	if network := c.String("network"); network != "" {
+		options.Network = network
+	}
+	steps, err := Import(env.Context, env.Conduit, env.Config, inventory, options)
+	if c.Bool("json") {
+		jsonData, _ := json.Marshal(steps)
+		fmt.Println(string(jsonData))
+	} else {
+		PrintImport(os.Stdout, steps, options.DryRun)
+	}
+	if err != nil {
+		return err
+	}
+	if !options.DryRun && ImportChanged(steps) {
+		return clearCache()
+	}
+	return nil
+}
+
+// runFactsSync prints the changed facts and writes them after the confirmation or with --yes.
+func runFactsSync(c *cli.Context) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	if directory := c.String("directory"); directory != "" {
+		env.Config.Facts.Directory = directory
+	}
+	output, err := env.List(true, false)
+	if err != nil {
+		return err
+	}
+	sources := env.Sources
+	if len(sources) == 0 {
+		sources = []InventorySource{{Config: env.Config, Conduit: env.Conduit}}
+	}
+	updates, err := PlanFactSync(env.Context, sources, output, env.Config)
+	if err != nil {
+		return err
+	}
+	diff := FactDiff(updates)
+	if c.Bool("json") {
+		fmt.Println(jsonText(diff))
+	} else {
+		PrintDiff(os.Stdout, diff)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	if !c.Bool("yes") {
+		info, err := os.Stdin.Stat()
+		if c.Bool("json") || err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			fmt.Fprintln(os.Stderr, "nothing is changed, use --yes to write the facts")
+			return nil
+		}
+		if !confirm(os.Stdin, os.Stdout, "Write the facts to Almanac?") {
+			return nil
+		}
+	}
+	if err = ApplyFactSync(env.Context, updates); err != nil {
+		return err
+	}
+	return clearCache()
+}
+
+// runPropertyGet prints the value of a property of a device or service.
+func runPropertyGet(c *cli.Context, key string) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	target, err := GetPropertyTarget(env.Context, env.Conduit, c.String("device"), c.String("service"))
+	if err != nil {
+		return err
+	}
+	value, found := target.Value(key)
+	if !found {
+		return NewError(DataError, nil, "%s %s has no property %s", target.Kind, target.Name, key)
+	}
+	fmt.Println(value)
+	return nil
+}
+
+// runPropertySet writes a property of a device or service and clears the inventory cache.
+func runPropertySet(c *cli.Context, key string, value string) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	if value == "-" {
+		content, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return NewError(ConfigError, err, "can not read the value from stdin")
+		}
+		value = strings.TrimRight(string(content), "\n")
+	}
+	if monogram := c.String("passphrase-ref"); monogram != "" {
+		value, err = PassphraseReference(env.Context, env.Conduit, env.Config, monogram)
+	} else {
+		value, err = EncodePropertyValue(key, value, env.Config)
+	}
+	if err != nil {
+		return err
+	}
+	target, err := GetPropertyTarget(env.Context, env.Conduit, c.String("device"), c.String("service"))
+	if err != nil {
+		return err
+	}
+	if old, found := target.Value(key); found && old == value {
+		logger.Info("property unchanged", "key", target.Key(key), target.Kind, target.Name)
+		return nil
+	}
+	if err = target.Set(env.Context, env.Conduit, map[string]string{key: value}); err != nil {
+		return err
+	}
+	logger.Info("property set", "key", target.Key(key), target.Kind, target.Name)
+	return clearCache()
+}
+
+// runPropertyUnset removes a property of a device or service and clears the inventory cache.
+func runPropertyUnset(c *cli.Context, key string) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	target, err := GetPropertyTarget(env.Context, env.Conduit, c.String("device"), c.String("service"))
+	if err != nil {
+		return err
+	}
+	removed, err := target.Unset(env.Context, env.Conduit, key)
+	if err != nil || !removed {
+		return err
+	}
+	logger.Info("property removed", "key", target.Key(key), target.Kind, target.Name)
+	return clearCache()
+}
+
+// runHostRegister adds the host to Almanac and clears the inventory cache when something changed.
+func runHostRegister(c *cli.Context, name string) error {
+	properties, err := ParsePropertyFlags(c.StringSlice("property"))
+	if err != nil {
+		return err
+	}
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	steps, err := RegisterHost(env.Context, env.Conduit, env.Config, RegisterOptions{
+		Name:       name,
+		Address:    c.String("address"),
+		Port:       c.Int("port"),
+		Network:    c.String("network"),
+		Services:   c.StringSlice("service"),
+		Properties: properties,
+	})
+	if c.Bool("json") {
+		jsonData, _ := json.Marshal(steps)
+		fmt.Println(string(jsonData))
+	} else {
+		PrintRegisterSteps(os.Stdout, steps)
+	}
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if step.Action != StepExists && step.Action != StepUnchanged {
+			return clearCache()
+		}
+	}
+	return nil
+}
+
+// runPrometheus creates the prometheus dynamic scraps from the Almanac repo
+func runPrometheus(c *cli.Context) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	status, err := GetStatusOptions(env.Config, "prometheus")
+	if err != nil {
+		return err
+	}
+	list, err := env.List(false, false)
+	if err != nil {
+		return err
+	}
+	list.ApplyStatus(status)
+	err = CheckProblems(list.ExpandTemplates(env.Config), false, 0)
+	if err != nil {
+		return err
+	}
+	prometheusData, problems := GetPrometheusData(list, env.Config.Wrapper.Json, splitGroups(c.String("ignore")))
+	if err = reportProblems(problems, env.Config.Inventory.MaxErrors); err != nil {
+		return err
+	}
+	jsonData, _ := json.Marshal(prometheusData)
+	fmt.Println(string(jsonData))
+	return nil
+}
+
+// runBlackbox creates the blackbox settings with modules as labels.
+// Should use relabeling to make parameter from the label.
+func runBlackbox(c *cli.Context) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	status, err := GetStatusOptions(env.Config, "blackbox")
+	if err != nil {
+		return err
+	}
+	list, err := env.List(false, false)
+	if err != nil {
+		return err
+	}
+	list.ApplyStatus(status)
+	err = CheckProblems(list.ExpandTemplates(env.Config), false, 0)
+	if err != nil {
+		return err
+	}
+	blackBoxData, problems := GetBlackBoxData(list, env.Config.Wrapper.Json, splitGroups(c.String("ignore")))
+	if err = reportProblems(problems, env.Config.Inventory.MaxErrors); err != nil {
+		return err
+	}
+	jsonData, _ := json.Marshal(blackBoxData)
+	fmt.Println(string(jsonData))
+	return nil
+}
+
+// runAlertManager reads the alertManager configs and rewrites with new routes.
+func runAlertManager(c *cli.Context, configPath string) error {
+	env, err := NewEnv(c)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	dataConfig, _, err := readAlertManagerConfig(configPath)
+	if err != nil {
+		return err
+	}
+	list, err := env.List(false, false)
+	if err != nil {
+		return err
+	}
+	return manageAlertManager(dataConfig, list, env.Config.Wrapper.Json, env.Config.Inventory.MaxErrors)
+}
+
+// runVagrantMapping prints the mapping of the machines from vagrant ssh-config or updates the given mapping file.
+func runVagrantMapping(c *cli.Context) error {
+	var sshConfig []byte
+	var err error
+	switch path := c.String("ssh-config"); path {
+	case "":
+		command := exec.Command("vagrant", "ssh-config")
+		command.Stderr = os.Stderr
+		sshConfig, err = command.Output()
+		if err != nil {
+			return NewError(ConfigError, err, "vagrant ssh-config failed")
+		}
+	case "-":
+		sshConfig, err = ioutil.ReadAll(os.Stdin)
+	default:
+		sshConfig, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return NewError(ConfigError, err, "can not read the ssh config")
+	}
+	mapping, err := ParseSSHConfig(bytes.NewReader(sshConfig))
+	if err != nil {
+		return err
+	}
+	update := c.String("update")
+	if update != "" {
+		existing := VagrantMapping{}
+		if _, statErr := os.Stat(update); statErr == nil {
+			existing, err = ReadVagrantMapping(update)
+			if err != nil {
+				return err
+			}
+		}
+		mapping = UpdateMapping(existing, mapping)
+	}
+	content, err := yaml.Marshal(mapping)
+	if err != nil {
+		return NewError(OutputError, err, "can not encode the vagrant mapping")
+	}
+	if update == "" {
+		fmt.Print(string(content))
+		return nil
+	}
+	err = ioutil.WriteFile(update, content, 0644)
+	if err != nil {
+		return NewError(OutputError, err, "can not write the vagrant mapping %s", update)
+	}
+	return nil
+}
+
+// runCacheInfo prints the cache files with their age and size.
+func runCacheInfo(c *cli.Context) error {
+	files, err := getAllCacheFiles()
+	if err != nil {
+		return NewError(OutputError, err, "can not list the cache files")
+	}
+	if len(files) == 0 {
+		fmt.Println("no cache")
+		return nil
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return NewError(OutputError, err, "can not read the cache file %s", file)
+		}
+		fmt.Printf("%s\t%d bytes\t%s old\n", file, info.Size(), time.Since(info.ModTime()).Round(time.Second))
+	}
+	return nil
+}
+
+// runCacheClear removes the cache files.
+func runCacheClear(c *cli.Context) error {
+	return clearCache()
+}
+
+// clearCache removes the cache files of all the namespaces, it is also used after a change in Almanac.
+func clearCache() error {
+	files, err := getAllCacheFiles()
+	if err != nil {
+		return NewError(OutputError, err, "can not list the cache files")
+	}
+	for _, file := range files {
+		err = os.Remove(file)
+		if err != nil {
+			return NewError(OutputError, err, "can not remove the cache file %s", file)
+		}
+		logger.Info("cache file removed", "file", file)
+	}
+	return nil
+}
+
+// runConfigPaths lists the configuration paths in the order they are read and which one is used.
+func runConfigPaths(c *cli.Context) error {
+	used := false
+	for _, path := range GetConfigPaths() {
+		status := "not found"
+		if _, err := os.Stat(path); err == nil {
+			err = checkConfigFile(path)
+			switch {
+			case used:
+				status = "ignored"
+			case err != nil && configPath != "":
+				status = "used, invalid: " + err.Error()
+				used = true
+			case err != nil:
+				status = "skipped, invalid: " + err.Error()
+			default:
+				status = "used"
+				used = true
+			}
+		}
+		fmt.Printf("%s\t%s\n", path, status)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOnlyOneTopLevelMode(t *testing.T) {
+	app := CreateCommandLine()
+	err := app.Run([]string{"a2a", "--list", "--prometheus"})
+	if ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error, got %v", err)
+	}
+}
+
+func TestSubcommandsNeedArguments(t *testing.T) {
+	app := CreateCommandLine()
+	for _, args := range [][]string{{"a2a", "inventory", "host"}, {"a2a", "alertmanager", "merge"}} {
+		err := app.Run(args)
+		if ExitCode(err) != exitCodes[ConfigError] {
+			t.Errorf("expected a configuration error for %v, got %v", args, err)
+		}
+	}
+}
+
+func TestInventoryAppliesStatusAndCompose(t *testing.T) {
+	responses := map[string]string{
+		"almanac.service.search": `{"data":[{"phid":"PHID-ASRV-1","fields":{"name":"web"},"attachments":{"properties":{"properties":[]},
+			"bindings":{"bindings":[{"disabled":true,"interface":{"address":"10.0.0.1","device":{"name":"web01"},"network":{"name":"dmz"}}}]}}}],"cursor":{"after":null}}`,
+		"almanac.device.search": `{"data":[{"phid":"PHID-ADEV-1","fields":{"name":"web01"},"attachments":{"properties":{"properties":[{"key":"role","value":"frontend"}]}}}],"cursor":{"after":null}}`,
+	}
+	var edits []map[string]interface{}
+	server := editServer(t, responses, &edits)
+	defer server.Close()
+	env := &Env{Config: defaultConfig(), Conduit: NewConduit(server.URL, "api-token"), Context: context.Background()}
+	env.Config.Wrapper.Passphrase = testPassphraseWrapper
+	env.Config.Wrapper.Json = testJsonWrapper
+	env.Config.Status.Inventory = StatusLabel
+	env.Config.Compose = map[string]*ComposedVar{"fqdn": {Expression: "{{ .role }}.example.com"}}
+	status, err := GetStatusOptions(env.Config, "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := env.Inventory(InventoryOptions{NoOverlay: true, MaxErrors: -1}, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// runHost prints these variables, so --host and --list agree.
+	vars := list.Meta.HostVars["web01"]
+	if vars[statusVar] != statusDisabled || vars["fqdn"] != "frontend.example.com" {
+		t.Errorf("expected the status label and the composed variable, got %v", vars)
+	}
+}
@@ -0,0 +1,589 @@
+package main
+
+// This file contains the minimal Conduit client used to talk to Phabricator.
+// Every call carries a context, so an overall deadline or Ctrl-C stops the
+// requests that are in flight. Read calls are retried with a jittered
+// exponential backoff, as they are idempotent.
+//
+// It replaces github.com/uniwue-rz/phabricator-go, whose calls take no context
+// and can not be cancelled or given a deadline, so a wrapper around it could only
+// stop waiting but not stop the request.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The defaults are used when the configuration does not set the values.
+const (
+	defaultRequestTimeout = 30 * time.Second
+	defaultRetries        = 3
+	defaultBackoff        = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	conduitPageLimit      = 100
+)
+
+// Conduit is the client for the Phabricator Conduit API.
+type Conduit struct {
+	ApiURL         string
+	ApiToken       string
+	Client         *http.Client
+	RequestTimeout time.Duration
+	Retries        int
+	Backoff        time.Duration
+	MaxBackoff     time.Duration
+}
+
+// ConduitError is returned when a Conduit call fails. It names the method and the
+// object the call was made for, so the user knows where to look.
+type ConduitError struct {
+	Method string
+	Object string
+	Code   string
+	Info   string
+	Status int
+	Err    error
+}
+
+func (e *ConduitError) Error() string {
+	message := "conduit " + e.Method
+	if e.Object != "" {
+		message += " (" + e.Object + ")"
+	}
+	switch {
+	case e.Err != nil:
+		message += ": " + e.Err.Error()
+	case e.Code != "":
+		message += ": " + e.Code + ": " + e.Info
+	case e.Status != 0:
+		message += ": " + http.StatusText(e.Status) + " (" + strconv.Itoa(e.Status) + ")"
+	}
+	return message
+}
+
+func (e *ConduitError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports if the call may succeed when it is repeated.
+func (e *ConduitError) Temporary() bool {
+	if e.Err != nil {
+		if errors.Is(e.Err, context.Canceled) {
+			return false
+		}
+		var netErr net.Error
+		return errors.Is(e.Err, context.DeadlineExceeded) || errors.As(e.Err, &netErr)
+	}
+	return e.Status >= 500 || e.Status == http.StatusTooManyRequests
+}
+
+// Property is a key value pair attached to Almanac services and devices.
+type Property struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Properties is the properties attachment of Almanac objects.
+type Properties struct {
+	Properties []Property `json:"properties"`
+}
+
+// DeviceRef is the device reference in the interface of a binding.
+type DeviceRef struct {
+	ID   int    `json:"id"`
+	PHID string `json:"phid"`
+	Name string `json:"name"`
+}
+
+// NetworkRef is the network reference in the interface of a binding.
+type NetworkRef struct {
+	ID   int    `json:"id"`
+	PHID string `json:"phid"`
+	Name string `json:"name"`
+}
+
+// Interface is the Almanac interface of a device.
+type Interface struct {
+	ID      int        `json:"id"`
+	PHID    string     `json:"phid"`
+	Address string     `json:"address"`
+	Port    int        `json:"port"`
+	Device  DeviceRef  `json:"device"`
+	Network NetworkRef `json:"network"`
+}
+
+// Binding binds a device interface to an Almanac service.
+type Binding struct {
+	ID         int        `json:"id"`
+	PHID       string     `json:"phid"`
+	Properties []Property `json:"properties"`
+	Disabled   bool       `json:"disabled"`
+	Interface  Interface  `json:"interface"`
+}
+
+// Service is an Almanac service which is translated to an Ansible group.
+type Service struct {
+	ID     int    `json:"id"`
+	PHID   string `json:"phid"`
+	Fields struct {
+		Name string `json:"name"`
+	} `json:"fields"`
+	Attachments struct {
+		Properties Properties `json:"properties"`
+		Bindings   struct {
+			Bindings []Binding `json:"bindings"`
+		} `json:"bindings"`
+	} `json:"attachments"`
+}
+
+// Device is an Almanac device which is translated to an Ansible host.
+type Device struct {
+	ID     int    `json:"id"`
+	PHID   string `json:"phid"`
+	Fields struct {
+		Name string `json:"name"`
+	} `json:"fields"`
+	Attachments struct {
+		Properties Properties `json:"properties"`
+		Projects   struct {
+			ProjectPHIDs []string `json:"projectPHIDs"`
+		} `json:"projects"`
+	} `json:"attachments"`
+}
+
+// Project is a Phabricator project, which can be used as tag of a device.
+type Project struct {
+	ID     int    `json:"id"`
+	PHID   string `json:"phid"`
+	Fields struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	} `json:"fields"`
+}
+
+// Namespace is an Almanac namespace, ex. dmz.example.com.
+type Namespace struct {
+	ID     int    `json:"id"`
+	PHID   string `json:"phid"`
+	Fields struct {
+		Name string `json:"name"`
+	} `json:"fields"`
+}
+
+// Network is an Almanac network, the interfaces of the devices are in a network.
+type Network struct {
+	ID     int    `json:"id"`
+	PHID   string `json:"phid"`
+	Fields struct {
+		Name string `json:"name"`
+	} `json:"fields"`
+}
+
+// DeviceInterface is an interface as returned by almanac.interface.search.
+type DeviceInterface struct {
+	ID     int    `json:"id"`
+	PHID   string `json:"phid"`
+	Fields struct {
+		DevicePHID  string `json:"devicePHID"`
+		NetworkPHID string `json:"networkPHID"`
+		Address     string `json:"address"`
+		Port        int    `json:"port"`
+	} `json:"fields"`
+}
+
+// Passphrase is the credential returned by passphrase.query.
+type Passphrase struct {
+	ID       int    `json:"id"`
+	PHID     string `json:"phid"`
+	Monogram string `json:"monogram"`
+	Material struct {
+		Password   string `json:"password"`
+		PrivateKey string `json:"privateKey"`
+	} `json:"material"`
+}
+
+// cursor is the paging information of the *.search methods.
+type cursor struct {
+	After string `json:"after"`
+}
+
+// NewConduit creates a new Conduit client with the default timeouts and retries.
+func NewConduit(apiURL string, apiToken string) *Conduit {
+	return &Conduit{
+		ApiURL:         apiURL,
+		ApiToken:       apiToken,
+		Client:         &http.Client{},
+		RequestTimeout: defaultRequestTimeout,
+		Retries:        defaultRetries,
+		Backoff:        defaultBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+	}
+}
+
+// Call runs the given Conduit method once and decodes the result into result.
+// It should be used for the methods that change data in Phabricator.
+func (c *Conduit) Call(ctx context.Context, method string, object string, params map[string]interface{}, result interface{}) error {
+	err := c.call(ctx, method, object, params, result)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Read runs the given read only Conduit method and retries it when it fails with
+// a temporary error. Between the tries it waits with a jittered exponential backoff.
+func (c *Conduit) Read(ctx context.Context, method string, object string, params map[string]interface{}, result interface{}) error {
+	for attempt := 0; ; attempt++ {
+		err := c.call(ctx, method, object, params, result)
+		if err == nil {
+			return nil
+		}
+		if attempt >= c.Retries || !err.Temporary() || ctx.Err() != nil {
+			return err
+		}
+		wait := c.backoff(attempt)
+		logger.Info("retrying conduit call", "method", method, "object", object,
+			"attempt", attempt+1, "wait", wait, "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return &ConduitError{Method: method, Object: object, Err: ctx.Err()}
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff returns the time to wait before the next try. It uses the full jitter
+// method, so parallel requests do not retry at the same time.
+func (c *Conduit) backoff(attempt int) time.Duration {
+	wait := c.Backoff << uint(attempt)
+	if wait <= 0 || (c.MaxBackoff > 0 && wait > c.MaxBackoff) {
+		wait = c.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(wait)))
+}
+
+// call sends one request to Conduit and logs it with its duration in trace mode.
+func (c *Conduit) call(ctx context.Context, method string, object string, params map[string]interface{}, result interface{}) *ConduitError {
+	start := time.Now()
+	err := c.send(ctx, method, params, result)
+	if err != nil {
+		err.Object = object
+		logger.Debug("conduit call failed", "method", method, "object", object,
+			"duration", time.Since(start), "error", err.Error())
+		return err
+	}
+	logger.Debug("conduit call", "method", method, "object", object, "duration", time.Since(start))
+	return nil
+}
+
+// send sends one request to Conduit with the per request timeout.
+func (c *Conduit) send(ctx context.Context, method string, params map[string]interface{}, result interface{}) *ConduitError {
+	if c.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+	}
+	body := make(map[string]interface{})
+	for k, v := range params {
+		body[k] = v
+	}
+	body["__conduit__"] = map[string]string{"token": c.ApiToken}
+	encodedParams, err := json.Marshal(body)
+	if err != nil {
+		return &ConduitError{Method: method, Err: err}
+	}
+	form := url.Values{}
+	form.Set("params", string(encodedParams))
+	form.Set("output", "json")
+	form.Set("__conduit__", "1")
+	endpoint := strings.TrimSuffix(c.ApiURL, "/") + "/" + method
+	request, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return &ConduitError{Method: method, Err: err}
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return &ConduitError{Method: method, Err: err}
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return &ConduitError{Method: method, Err: err}
+	}
+	if response.StatusCode != http.StatusOK {
+		return &ConduitError{Method: method, Status: response.StatusCode}
+	}
+	var envelope struct {
+		Result    json.RawMessage `json:"result"`
+		ErrorCode *string         `json:"error_code"`
+		ErrorInfo *string         `json:"error_info"`
+	}
+	err = json.Unmarshal(content, &envelope)
+	if err != nil {
+		return &ConduitError{Method: method, Err: fmt.Errorf("invalid response: %v", err)}
+	}
+	if envelope.ErrorCode != nil {
+		conduitError := &ConduitError{Method: method, Code: *envelope.ErrorCode}
+		if envelope.ErrorInfo != nil {
+			conduitError.Info = *envelope.ErrorInfo
+		}
+		return conduitError
+	}
+	if result != nil {
+		err = json.Unmarshal(envelope.Result, result)
+		if err != nil {
+			return &ConduitError{Method: method, Err: fmt.Errorf("invalid result: %v", err)}
+		}
+	}
+	return nil
+}
+
+// search reads all the pages of a *.search method, page is called with the data of every page.
+func (c *Conduit) search(ctx context.Context, method string, object string, params map[string]interface{}, page func(data json.RawMessage) error) error {
+	after := ""
+	for {
+		pageParams := map[string]interface{}{"limit": conduitPageLimit}
+		for key, value := range params {
+			pageParams[key] = value
+		}
+		if after != "" {
+			pageParams["after"] = after
+		}
+		var result struct {
+			Data   json.RawMessage `json:"data"`
+			Cursor cursor          `json:"cursor"`
+		}
+		err := c.Read(ctx, method, object, pageParams, &result)
+		if err != nil {
+			return err
+		}
+		err = page(result.Data)
+		if err != nil {
+			return &ConduitError{Method: method, Object: object, Err: err}
+		}
+		if result.Cursor.After == "" {
+			return nil
+		}
+		after = result.Cursor.After
+	}
+}
+
+// GetServices returns all the Almanac services with their properties and bindings.
+func (c *Conduit) GetServices(ctx context.Context) (services []Service, err error) {
+	params := map[string]interface{}{
+		"attachments": map[string]bool{"properties": true, "bindings": true},
+	}
+	err = c.search(ctx, "almanac.service.search", "services", params, func(data json.RawMessage) error {
+		var page []Service
+		err := json.Unmarshal(data, &page)
+		services = append(services, page...)
+		return err
+	})
+	if err == nil {
+		logger.Info("services fetched", "count", len(services))
+	}
+	return services, err
+}
+
+// GetService returns the Almanac services with the given name, their properties and bindings.
+func (c *Conduit) GetService(ctx context.Context, name string) (services []Service, err error) {
+	params := map[string]interface{}{
+		"constraints": map[string]interface{}{"names": []string{name}},
+		"attachments": map[string]bool{"properties": true, "bindings": true},
+	}
+	var result struct {
+		Data []Service `json:"data"`
+	}
+	err = c.Read(ctx, "almanac.service.search", "service "+name, params, &result)
+	return result.Data, err
+}
+
+// GetDevice returns the Almanac devices with the given name and their properties.
+func (c *Conduit) GetDevice(ctx context.Context, name string) (devices []Device, err error) {
+	params := map[string]interface{}{
+		"constraints": map[string]interface{}{"names": []string{name}},
+		"attachments": map[string]bool{"properties": true},
+	}
+	var result struct {
+		Data []Device `json:"data"`
+	}
+	err = c.Read(ctx, "almanac.device.search", "device "+name, params, &result)
+	return result.Data, err
+}
+
+// GetDevices returns the Almanac devices with the given names and the given attachments.
+func (c *Conduit) GetDevices(ctx context.Context, names []string, attachments map[string]bool) (devices []Device, err error) {
+	for start := 0; start < len(names); start += conduitPageLimit {
+		end := start + conduitPageLimit
+		if end > len(names) {
+			end = len(names)
+		}
+		params := map[string]interface{}{
+			"constraints": map[string]interface{}{"names": names[start:end]},
+			"attachments": attachments,
+		}
+		err = c.search(ctx, "almanac.device.search", "devices", params, func(data json.RawMessage) error {
+			var page []Device
+			err := json.Unmarshal(data, &page)
+			devices = append(devices, page...)
+			return err
+		})
+		if err != nil {
+			return devices, err
+		}
+	}
+	return devices, nil
+}
+
+// GetAllDevices returns all the Almanac devices with their properties.
+func (c *Conduit) GetAllDevices(ctx context.Context) (devices []Device, err error) {
+	params := map[string]interface{}{
+		"attachments": map[string]bool{"properties": true},
+	}
+	err = c.search(ctx, "almanac.device.search", "devices", params, func(data json.RawMessage) error {
+		var page []Device
+		err := json.Unmarshal(data, &page)
+		devices = append(devices, page...)
+		return err
+	})
+	return devices, err
+}
+
+// GetProjects returns the projects with the given PHIDs.
+func (c *Conduit) GetProjects(ctx context.Context, phids []string) (projects []Project, err error) {
+	if len(phids) == 0 {
+		return projects, nil
+	}
+	params := map[string]interface{}{
+		"constraints": map[string]interface{}{"phids": phids},
+	}
+	err = c.search(ctx, "project.search", "projects", params, func(data json.RawMessage) error {
+		var page []Project
+		err := json.Unmarshal(data, &page)
+		projects = append(projects, page...)
+		return err
+	})
+	return projects, err
+}
+
+// GetNamespaces returns all the Almanac namespaces.
+func (c *Conduit) GetNamespaces(ctx context.Context) (namespaces []Namespace, err error) {
+	err = c.search(ctx, "almanac.namespace.search", "namespaces", nil, func(data json.RawMessage) error {
+		var page []Namespace
+		err := json.Unmarshal(data, &page)
+		namespaces = append(namespaces, page...)
+		return err
+	})
+	return namespaces, err
+}
+
+// Edit runs an Almanac edit transaction, kind is the object type like device or binding. Without
+// identifier a new object is created. It returns the PHID of the object.
+func (c *Conduit) Edit(ctx context.Context, kind string, identifier string, object string, transactions []map[string]interface{}) (phid string, err error) {
+	params := map[string]interface{}{"transactions": transactions}
+	if identifier != "" {
+		params["objectIdentifier"] = identifier
+	}
+	var result struct {
+		Object struct {
+			PHID string `json:"phid"`
+		} `json:"object"`
+	}
+	err = c.Call(ctx, "almanac."+kind+".edit", kind+" "+object, params, &result)
+	return result.Object.PHID, err
+}
+
+// EditProperties sets and removes properties of an Almanac device or service in one edit
+// transaction. kind is device or service, identifier its PHID.
+func (c *Conduit) EditProperties(ctx context.Context, kind string, identifier string, name string, set map[string]string, remove []string) error {
+	transactions := make([]map[string]interface{}, 0, 2)
+	if len(set) > 0 {
+		transactions = append(transactions, map[string]interface{}{"type": "property.set", "value": set})
+	}
+	if len(remove) > 0 {
+		transactions = append(transactions, map[string]interface{}{"type": "property.delete", "value": remove})
+	}
+	if len(transactions) == 0 {
+		return nil
+	}
+	_, err := c.Edit(ctx, kind, identifier, name, transactions)
+	return err
+}
+
+// GetNetworks returns the Almanac networks with the given name.
+func (c *Conduit) GetNetworks(ctx context.Context, name string) (networks []Network, err error) {
+	params := map[string]interface{}{
+		"constraints": map[string]interface{}{"names": []string{name}},
+	}
+	var result struct {
+		Data []Network `json:"data"`
+	}
+	err = c.Read(ctx, "almanac.network.search", "network "+name, params, &result)
+	return result.Data, err
+}
+
+// GetInterfaces returns the interfaces of the device with the given PHID.
+func (c *Conduit) GetInterfaces(ctx context.Context, devicePHID string, device string) (interfaces []DeviceInterface, err error) {
+	params := map[string]interface{}{
+		"constraints": map[string]interface{}{"devicePHIDs": []string{devicePHID}},
+	}
+	err = c.search(ctx, "almanac.interface.search", "interfaces of "+device, params, func(data json.RawMessage) error {
+		var page []DeviceInterface
+		err := json.Unmarshal(data, &page)
+		interfaces = append(interfaces, page...)
+		return err
+	})
+	return interfaces, err
+}
+
+// GetPassphrase returns the passphrase with the given monogram (ex. K42) and its secret.
+func (c *Conduit) GetPassphrase(ctx context.Context, monogram string) (passphrases []Passphrase, err error) {
+	params := map[string]interface{}{"needSecrets": true}
+	id, err := strconv.Atoi(strings.TrimPrefix(monogram, "K"))
+	if err == nil {
+		params["ids"] = []int{id}
+	} else {
+		params["phids"] = []string{monogram}
+	}
+	var result struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err = c.Read(ctx, "passphrase.query", "passphrase "+monogram, params, &result)
+	if err != nil {
+		return passphrases, err
+	}
+	// An empty result is encoded by PHP as a list and not as an object.
+	data := make(map[string]Passphrase)
+	if strings.HasPrefix(strings.TrimSpace(string(result.Data)), "{") {
+		err = json.Unmarshal(result.Data, &data)
+		if err != nil {
+			return passphrases, &ConduitError{Method: "passphrase.query", Object: "passphrase " + monogram, Err: err}
+		}
+	}
+	for _, passphrase := range data {
+		passphrases = append(passphrases, passphrase)
+	}
+	return passphrases, nil
+}
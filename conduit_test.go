@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConduitReadRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"result":{"data":[{"fields":{"name":"web"}}],"cursor":{"after":null}},"error_code":null,"error_info":null}`))
+	}))
+	defer server.Close()
+
+	p := NewConduit(server.URL, "api-token")
+	p.Backoff = time.Millisecond
+	services, err := p.GetServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].Fields.Name != "web" {
+		t.Errorf("unexpected services %v", services)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestConduitErrorNamesMethodAndObject(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":null,"error_code":"ERR-INVALID-AUTH","error_info":"API token is invalid."}`))
+	}))
+	defer server.Close()
+
+	p := NewConduit(server.URL, "api-token")
+	_, err := p.GetDevice(context.Background(), "web01")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "almanac.device.search") || !strings.Contains(err.Error(), "web01") {
+		t.Errorf("error does not name the method and object: %v", err)
+	}
+}
+
+func TestConduitReadStopsOnCancel(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	p := NewConduit(server.URL, "api-token")
+	p.Backoff = time.Hour
+	p.MaxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := p.GetServices(ctx)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestConduitTraceLogsCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":{"data":[]},"error_code":null,"error_info":null}`))
+	}))
+	defer server.Close()
+
+	var buffer bytes.Buffer
+	defaultLogger := logger
+	logger = NewLogger(&buffer, slog.LevelDebug, LogFormatJson)
+	defer func() { logger = defaultLogger }()
+
+	p := NewConduit(server.URL, "api-token")
+	_, err := p.GetDevice(context.Background(), "web01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]interface{}
+	err = json.Unmarshal(buffer.Bytes(), &line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line["method"] != "almanac.device.search" || line["duration"] == nil {
+		t.Errorf("unexpected log line %v", line)
+	}
+	if bytes.Contains(buffer.Bytes(), []byte("api-token")) {
+		t.Error("the token should not be logged")
+	}
+}
+
+func TestConduitSearchFollowsCursor(t *testing.T) {
+	var afters []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]interface{}
+		json.Unmarshal([]byte(r.FormValue("params")), &params)
+		after, _ := params["after"].(string)
+		afters = append(afters, after)
+		if after == "" {
+			w.Write([]byte(`{"result":{"data":[{"fields":{"name":"web01"}}],"cursor":{"after":"2"}},"error_code":null,"error_info":null}`))
+			return
+		}
+		w.Write([]byte(`{"result":{"data":[{"fields":{"name":"web02"}}],"cursor":{"after":null}},"error_code":null,"error_info":null}`))
+	}))
+	defer server.Close()
+
+	p := NewConduit(server.URL, "api-token")
+	devices, err := p.GetAllDevices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 || devices[1].Fields.Name != "web02" {
+		t.Errorf("unexpected devices %v", devices)
+	}
+	if len(afters) != 2 || afters[1] != "2" {
+		t.Errorf("unexpected pages %v", afters)
+	}
+}
+
+func TestConduitDoesNotRetry(t *testing.T) {
+	tests := map[string]struct {
+		status   int
+		response string
+		write    bool
+	}{
+		"edit":          {status: http.StatusBadGateway, write: true},
+		"client error":  {status: http.StatusForbidden},
+		"conduit error": {status: http.StatusOK, response: `{"result":null,"error_code":"ERR-INVALID-AUTH","error_info":"API token is invalid."}`},
+	}
+	for name, test := range tests {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.response))
+		}))
+		p := NewConduit(server.URL, "api-token")
+		p.Backoff = time.Millisecond
+		var err error
+		if test.write {
+			_, err = p.Edit(context.Background(), "device", "", "web01", nil)
+		} else {
+			_, err = p.GetServices(context.Background())
+		}
+		server.Close()
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if calls != 1 {
+			t.Errorf("%s: expected 1 call, got %d", name, calls)
+		}
+	}
+}
+
+func TestConduitRequestTimeout(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(`{"result":{"data":[]},"error_code":null,"error_info":null}`))
+	}))
+	defer server.Close()
+
+	p := NewConduit(server.URL, "api-token")
+	p.RequestTimeout = 50 * time.Millisecond
+	p.Backoff = time.Millisecond
+	if _, err := p.GetServices(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("the timed out request should be retried, got %d calls", calls)
+	}
+}
+
+func TestConduitPassphrase(t *testing.T) {
+	response := `{"PHID-PASS-1":{"id":42,"material":{"password":"s3cret"}}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":{"data":` + response + `},"error_code":null,"error_info":null}`))
+	}))
+	defer server.Close()
+
+	p := NewConduit(server.URL, "api-token")
+	passphrases, err := p.GetPassphrase(context.Background(), "K42")
+	if err != nil || len(passphrases) != 1 {
+		t.Fatalf("unexpected passphrases %v %v", passphrases, err)
+	}
+	// PHP encodes an empty result as a list.
+	response = `[]`
+	passphrases, err = p.GetPassphrase(context.Background(), "K43")
+	if err != nil || len(passphrases) != 0 {
+		t.Errorf("unexpected passphrases %v %v", passphrases, err)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+// The configuration is read from the file given with --config or A2A_CONFIG, or from the
+// first existing file of the default paths. The selected profile is applied next. The API URL and token can be overwritten with
+// A2A_API_URL and A2A_API_TOKEN, and the token can be read from a file or a command, so it
+// does not have to be written in the configuration file.
+
+import (
+	"fmt"
+	"gopkg.in/gcfg.v1"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// The environment variables read by a2a.
+const (
+	envConfig   = "A2A_CONFIG"
+	envApiURL   = "A2A_API_URL"
+	envApiToken = "A2A_API_TOKEN"
+)
+
+// The sources of the configuration values.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+)
+
+// configPath is set with --config or A2A_CONFIG and replaces the default paths.
+var configPath = ""
+
+// ConfigSources describes where the configuration values come from.
+type ConfigSources struct {
+	// Path is the configuration file that is used.
+	Path string
+	// Profile is the selected profile.
+	Profile string
+	// Values maps the keys (ex. Phabricator.ApiURL) to their source.
+	Values map[string]string
+}
+
+// GetConfigPaths returns the list of config paths for the given system
+func GetConfigPaths() []string {
+	if configPath != "" {
+		return []string{configPath}
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return []string{"/etc/a2a/config", "config"}
+	}
+	homePath := usr.HomeDir + "/.a2a/config"
+	return []string{"/etc/a2a/config", homePath, "config"}
+}
+
+// defaultConfig returns the configuration with the default values.
+func defaultConfig() (Config Configuration) {
+	Config.Phabricator.Retries = defaultRetries
+	Config.Inventory.MaxErrors = -1
+	Config.Template.Left = "{{"
+	Config.Template.Right = "}}"
+	Config.Status.Property = "a2a-status"
+	Config.Status.Group = "maintenance"
+	Config.AutoGroups.NetworkPrefix = "net_"
+	Config.AutoGroups.ProjectPrefix = "project_"
+	Config.AutoGroups.NamespacePrefix = "ns_"
+	Config.Import.Secret = defaultSecretKeys
+	Config.Facts.Prefix = "fact-"
+	return Config
+}
+
+// ReadConfig reads the configuration from the configurations
+func ReadConfig() (Config Configuration, err error) {
+	Config, _, err = LoadConfig()
+	return Config, err
+}
+
+// LoadConfig reads the configuration, applies the selected profile and the environment
+// overrides and resolves the API token. It returns the sources of the values too.
+func LoadConfig() (Config Configuration, sources ConfigSources, err error) {
+	return loadConfig(profileName, true)
+}
+
+// loadConfig reads the configuration with the given profile. The environment overrides are
+// only applied when overrides is set.
+func loadConfig(profile string, overrides bool) (Config Configuration, sources ConfigSources, err error) {
+	sources.Values = make(map[string]string)
+	sources.Path, err = findConfigPath()
+	if err != nil {
+		return Config, sources, err
+	}
+	Config = defaultConfig()
+	err = gcfg.ReadFileInto(&Config, sources.Path)
+	if err != nil {
+		return Config, sources, NewError(ConfigError, err, "can not read the configuration %s", sources.Path)
+	}
+	// The file is read a second time without the defaults, so the values set in the
+	// file can be told apart from the defaults.
+	var fileConfig Configuration
+	err = gcfg.ReadFileInto(&fileConfig, sources.Path)
+	if err != nil {
+		return Config, sources, NewError(ConfigError, err, "can not read the configuration %s", sources.Path)
+	}
+	defaults := defaultConfig()
+	walkConfig(reflect.ValueOf(Config), "", func(key string, value reflect.Value) {
+		fileValue := lookupConfigValue(fileConfig, key)
+		defaultValue := lookupConfigValue(defaults, key)
+		if (fileValue.IsValid() && !fileValue.IsZero()) ||
+			(defaultValue.IsValid() && !reflect.DeepEqual(value.Interface(), defaultValue.Interface())) {
+			sources.Values[key] = sourceFile + " " + sources.Path
+		} else {
+			sources.Values[key] = sourceDefault
+		}
+	})
+	if profile == "" && binaryProfile != "" {
+		if _, found := Config.Profile[binaryProfile]; found {
+			profile = binaryProfile
+		} else {
+			logger.Debug("the binary name selects no profile", "profile", binaryProfile)
+		}
+	}
+	if profile != "" {
+		sources.Profile = profile
+		err = applyProfile(&Config, profile, sources)
+		if err != nil {
+			return Config, sources, err
+		}
+	}
+	if strings.ContainsAny(Config.Cache.Namespace, "/\\*?[") {
+		return Config, sources, NewError(ConfigError, nil, "invalid cache namespace %q", Config.Cache.Namespace)
+	}
+	if !overrides {
+		err = resolveApiToken(&Config.Phabricator.ApiToken, Config.Phabricator.ApiTokenFile,
+			Config.Phabricator.ApiTokenCommand, "Phabricator", sources)
+		return Config, sources, err
+	}
+	err = applyConfigOverrides(&Config, sources)
+	return Config, sources, err
+}
+
+// findConfigPath returns the configuration file. An explicit --config or A2A_CONFIG must be
+// valid, of the default paths the first one that can be parsed is used, like before.
+func findConfigPath() (string, error) {
+	if configPath != "" {
+		if _, err := os.Stat(configPath); err != nil {
+			return configPath, NewError(ConfigError, err, "the configuration %s is not found", configPath)
+		}
+		return configPath, nil
+	}
+	return firstValidConfig(GetConfigPaths())
+}
+
+// firstValidConfig returns the first of the paths that exists and can be parsed. The invalid
+// files are skipped with a warning, so a broken /etc/a2a/config does not hide ~/.a2a/config.
+func firstValidConfig(paths []string) (string, error) {
+	invalid := ""
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		err := checkConfigFile(path)
+		if err == nil {
+			return path, nil
+		}
+		logger.Warn("the configuration can not be parsed and is skipped", "file", path, "error", err.Error())
+		if invalid == "" {
+			invalid = path
+		}
+	}
+	if invalid != "" {
+		return invalid, NewError(ConfigError, nil, "the configuration %s can not be parsed and no other one is found, "+
+			"fix it or use --config", invalid)
+	}
+	return "", NewError(ConfigError, nil, "the configuration is not found in one of %s", strings.Join(paths, ", "))
+}
+
+// checkConfigFile returns the error of parsing the configuration file.
+func checkConfigFile(path string) error {
+	var Config Configuration
+	return gcfg.ReadFileInto(&Config, path)
+}
+
+// applyConfigOverrides sets the values from the environment and resolves the API token
+// from ApiTokenFile or ApiTokenCommand.
+func applyConfigOverrides(Config *Configuration, sources ConfigSources) error {
+	if apiURL := os.Getenv(envApiURL); apiURL != "" {
+		Config.Phabricator.ApiURL = apiURL
+		sources.Values["Phabricator.ApiURL"] = sourceEnv + " " + envApiURL
+	}
+	if apiToken := os.Getenv(envApiToken); apiToken != "" {
+		Config.Phabricator.ApiToken = apiToken
+		sources.Values["Phabricator.ApiToken"] = sourceEnv + " " + envApiToken
+		return nil
+	}
+	return resolveApiToken(&Config.Phabricator.ApiToken, Config.Phabricator.ApiTokenFile,
+		Config.Phabricator.ApiTokenCommand, "Phabricator", sources)
+}
+
+// resolveApiToken reads the token from the given file or command. Only one of the token,
+// the file and the command can be set in a section.
+func resolveApiToken(apiToken *string, tokenFile string, tokenCommand string, section string, sources ConfigSources) error {
+	set := 0
+	for _, value := range []string{*apiToken, tokenFile, tokenCommand} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return NewError(ConfigError, nil, "only one of %[1]s.ApiToken, %[1]s.ApiTokenFile and %[1]s.ApiTokenCommand can be set", section)
+	}
+	switch {
+	case tokenFile != "":
+		content, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return NewError(ConfigError, err, "can not read %s.ApiTokenFile", section)
+		}
+		*apiToken = strings.TrimSpace(string(content))
+		sources.Values[section+".ApiToken"] = "ApiTokenFile " + tokenFile
+	case tokenCommand != "":
+		command := exec.Command("sh", "-c", tokenCommand)
+		command.Stderr = os.Stderr
+		content, err := command.Output()
+		if err != nil {
+			return NewError(ConfigError, err, "%s.ApiTokenCommand failed", section)
+		}
+		*apiToken = strings.TrimSpace(string(content))
+		sources.Values[section+".ApiToken"] = "ApiTokenCommand"
+	}
+	return nil
+}
+
+// walkConfig calls fn for every value of the configuration with its key, ex. Phabricator.ApiURL.
+func walkConfig(value reflect.Value, prefix string, fn func(key string, value reflect.Value)) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			walkConfig(value.Elem(), prefix, fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			walkConfig(value.Field(i), joinConfigKey(prefix, field.Name), fn)
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			walkConfig(value.MapIndex(key), fmt.Sprintf("%s %q", prefix, key.String()), fn)
+		}
+	default:
+		fn(prefix, value)
+	}
+}
+
+// joinConfigKey joins the section and the name of a configuration key.
+func joinConfigKey(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// lookupConfigValue returns the value for the given key or an invalid value if the key does not exist.
+func lookupConfigValue(Config Configuration, key string) (found reflect.Value) {
+	walkConfig(reflect.ValueOf(Config), "", func(k string, value reflect.Value) {
+		if k == key {
+			found = value
+		}
+	})
+	return found
+}
+
+// maskSecret hides all but the first four characters of a secret.
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", len(secret)-4)
+}
+
+// PrintConfig writes the effective configuration with the source of every value. The API
+// tokens are masked.
+func PrintConfig(Config Configuration, sources ConfigSources) {
+	fmt.Printf("# %s\n", sources.Path)
+	if sources.Profile != "" {
+		fmt.Printf("# profile %s\n", sources.Profile)
+	}
+	walkConfig(reflect.ValueOf(Config), "", func(key string, value reflect.Value) {
+		text := fmt.Sprint(value.Interface())
+		if strings.HasSuffix(key, "ApiToken") {
+			text = maskSecret(text)
+		}
+		source := sources.Values[key]
+		if source == "" {
+			source = sourceDefault
+		}
+		fmt.Printf("%s = %q\t# %s\n", key, text, source)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestConfig writes the given configuration to a temporary file and points configPath to it.
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := configPath
+	configPath = path
+	t.Cleanup(func() { configPath = oldPath })
+	return path
+}
+
+func TestLoadConfigSources(t *testing.T) {
+	path := writeTestConfig(t, "[Phabricator]\nApiURL = https://file.example/api\nApiToken = api-file\n")
+	t.Setenv(envApiURL, "https://env.example/api")
+	t.Setenv(envApiToken, "")
+	Config, sources, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Config.Phabricator.ApiURL != "https://env.example/api" {
+		t.Errorf("expected the environment to override the file, got %s", Config.Phabricator.ApiURL)
+	}
+	if sources.Values["Phabricator.ApiURL"] != sourceEnv+" "+envApiURL {
+		t.Errorf("unexpected source %q", sources.Values["Phabricator.ApiURL"])
+	}
+	if sources.Values["Phabricator.ApiToken"] != sourceFile+" "+path {
+		t.Errorf("unexpected source %q", sources.Values["Phabricator.ApiToken"])
+	}
+	if sources.Values["Phabricator.Retries"] != sourceDefault {
+		t.Errorf("unexpected source %q", sources.Values["Phabricator.Retries"])
+	}
+}
+
+func TestLoadConfigMissingExplicitPath(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), "missing")
+	defer func() { configPath = oldPath }()
+	_, _, err := LoadConfig()
+	if ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error, got %v", err)
+	}
+}
+
+func TestResolveApiToken(t *testing.T) {
+	sources := ConfigSources{Values: map[string]string{}}
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := ioutil.WriteFile(tokenFile, []byte("api-from-file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token string
+	if err = resolveApiToken(&token, tokenFile, "", "Phabricator", sources); err != nil || token != "api-from-file" {
+		t.Errorf("unexpected token %q, %v", token, err)
+	}
+	token = ""
+	if err = resolveApiToken(&token, "", "echo api-from-command", "Phabricator", sources); err != nil || token != "api-from-command" {
+		t.Errorf("unexpected token %q, %v", token, err)
+	}
+	token = "api-token"
+	if err = resolveApiToken(&token, tokenFile, "", "Phabricator", sources); ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error for two token sources, got %v", err)
+	}
+	token = ""
+	if err = resolveApiToken(&token, "", "exit 1", "Phabricator", sources); ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error for a failing command, got %v", err)
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	if maskSecret("api-abcdef") != "api-******" {
+		t.Errorf("unexpected mask %s", maskSecret("api-abcdef"))
+	}
+	if maskSecret("abc") != "***" {
+		t.Errorf("unexpected mask %s", maskSecret("abc"))
+	}
+}
+
+func TestFirstValidConfigSkipsBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "system")
+	valid := filepath.Join(dir, "home")
+	ioutil.WriteFile(broken, []byte("[Phabricator\nApiURL = https://example/api\n"), 0600)
+	ioutil.WriteFile(valid, []byte("[Phabricator]\nApiURL = https://example/api\n"), 0600)
+
+	path, err := firstValidConfig([]string{filepath.Join(dir, "missing"), broken, valid})
+	if err != nil || path != valid {
+		t.Errorf("expected %s, got %s %v", valid, path, err)
+	}
+	path, err = firstValidConfig([]string{broken})
+	if ExitCode(err) != exitCodes[ConfigError] || path != broken || !strings.Contains(err.Error(), broken) {
+		t.Errorf("expected a configuration error naming %s, got %v", broken, err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+// The constructed groups and variables work like the constructed inventory plugin of Ansible,
+// which can not be chained to an inventory script. Composed variables are Go templates over
+// the variables of a host. Keyed groups create a group for every value of a variable, ex.
+// os=debian adds the host to os_debian.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// KeyedGroup is a [Keyed "name"] section of the configuration.
+type KeyedGroup struct {
+	// Key is the host variable, ex. os or hardware.arch for a value in a json variable.
+	Key string
+	// Prefix of the group names, the name of the section per default.
+	Prefix string
+	// Separator between the prefix and the value, _ per default.
+	Separator string
+	// Default is used for the hosts without the variable, they are not grouped when it is not set.
+	Default string
+}
+
+// ComposedVar is a [Compose "name"] section of the configuration.
+type ComposedVar struct {
+	// Expression is a Go template over the host variables, ex. {{ .hostname }}.{{ .domain }}.
+	Expression string
+	// Strict reports the hosts where the expression fails, otherwise they are skipped.
+	Strict bool
+}
+
+// unsafeGroupChars are the characters that are replaced in the constructed group names.
+var unsafeGroupChars = regexp.MustCompile("[^A-Za-z0-9_]")
+
+// templateFuncs are the functions that can be used in the templates.
+var templateFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": strings.ReplaceAll,
+	"join": func(items []interface{}, separator string) string {
+		texts := make([]string, len(items))
+		for i, item := range items {
+			texts[i] = fmt.Sprint(item)
+		}
+		return strings.Join(texts, separator)
+	},
+	"default": func(fallback interface{}, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+// Construct adds the composed variables and the keyed groups of the configuration to the
+// inventory. The variables are composed first, so they can be used for the keyed groups.
+func (output *Output) Construct(Config Configuration) (problems []InventoryError, err error) {
+	if len(Config.Compose) == 0 && len(Config.Keyed) == 0 {
+		return problems, nil
+	}
+	hosts := make([]string, 0, len(output.Meta.HostVars))
+	for host := range output.Meta.HostVars {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, name := range sortedKeys(Config.Compose) {
+		composed := Config.Compose[name]
+		tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(composed.Expression)
+		if err != nil {
+			return problems, NewError(ConfigError, err, "invalid expression in Compose %q", name)
+		}
+		for _, host := range hosts {
+			var text strings.Builder
+			err = tmpl.Execute(&text, output.varsOf(host))
+			if err != nil {
+				if composed.Strict {
+					err = NewError(DataError, err, "can not compose %s", name)
+					problems = append(problems, InventoryError{Device: host, Property: name, Reason: err.Error(), err: err})
+				}
+				logger.Debug("composed variable skipped", "host", host, "var", name, "error", err)
+				continue
+			}
+			output.Meta.HostVars[host][name] = text.String()
+		}
+	}
+
+	for _, name := range sortedKeys(Config.Keyed) {
+		keyed := Config.Keyed[name]
+		prefix, separator := keyed.Prefix, keyed.Separator
+		if prefix == "" {
+			prefix = name
+		}
+		if separator == "" {
+			separator = "_"
+		}
+		for _, host := range hosts {
+			value, found := lookupVar(output.varsOf(host), keyed.Key)
+			if !found || value == nil {
+				if keyed.Default == "" {
+					continue
+				}
+				value = keyed.Default
+			}
+			for _, key := range keyedValues(value) {
+				output.AddHost(host, unsafeGroupChars.ReplaceAllString(prefix+separator+key, "_"))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// varsOf returns the variables of the host, which are the variables of its groups and its
+// own variables, like Ansible merges them.
+func (output *Output) varsOf(host string) map[string]interface{} {
+	vars := make(map[string]interface{})
+	for _, name := range output.GroupNames() {
+		group := output.Group[name]
+		if !containsString(group.Hosts, host) {
+			continue
+		}
+		for key, value := range group.Vars {
+			vars[key] = value
+		}
+	}
+	for key, value := range output.Meta.HostVars[host] {
+		vars[key] = value
+	}
+	return vars
+}
+
+// lookupVar returns the variable for the given key, the parts of a key like hardware.arch
+// are looked up in the json values.
+func lookupVar(vars map[string]interface{}, key string) (value interface{}, found bool) {
+	value = vars
+	for _, part := range strings.Split(key, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, found = object[part]
+		if !found {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// keyedValues returns the group keys for a value. Lists give a key for every item and
+// objects a key for every key_value pair.
+func keyedValues(value interface{}) (keys []string) {
+	switch typed := value.(type) {
+	case []interface{}:
+		for _, item := range typed {
+			keys = append(keys, fmt.Sprint(item))
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(typed) {
+			keys = append(keys, key+"_"+fmt.Sprint(typed[key]))
+		}
+	case string:
+		if typed != "" {
+			keys = append(keys, typed)
+		}
+	default:
+		keys = append(keys, fmt.Sprint(typed))
+	}
+	return keys
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys[V any](items map[string]V) []string {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
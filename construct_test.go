@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConstruct(t *testing.T) {
+	var output Output
+	output.Group = map[string]Group{"web": {Hosts: []string{"web01", "web02"}, Vars: map[string]interface{}{"domain": "example.com"}}}
+	output.Meta.HostVars = map[string]map[string]interface{}{
+		"web01": {"os": "Debian", "hostname": "web01", "hardware": map[string]interface{}{"arch": "x86-64"}, "roles": []interface{}{"nginx", "php"}},
+		"web02": {"hostname": "web02"},
+	}
+	var Config Configuration
+	Config.Compose = map[string]*ComposedVar{
+		"fqdn":     {Expression: "{{ .hostname }}.{{ .domain }}"},
+		"os_lower": {Expression: "{{ .os | lower }}"},
+		"location": {Expression: "{{ .rack }}", Strict: true},
+	}
+	Config.Keyed = map[string]*KeyedGroup{
+		"os":   {Key: "os_lower", Default: "unknown"},
+		"arch": {Key: "hardware.arch", Prefix: "cpu", Separator: "-"},
+		"role": {Key: "roles"},
+	}
+	problems, err := output.Construct(Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Meta.HostVars["web01"]["fqdn"] != "web01.example.com" {
+		t.Errorf("unexpected composed variable %v", output.Meta.HostVars["web01"]["fqdn"])
+	}
+	if _, ok := output.Meta.HostVars["web02"]["os_lower"]; ok {
+		t.Error("the composed variable should be skipped for hosts without os")
+	}
+	if len(problems) != 2 || problems[0].Property != "location" {
+		t.Errorf("expected a problem for every host in strict mode, got %v", problems)
+	}
+	expected := map[string][]string{
+		"os_debian":  {"web01"},
+		"os_unknown": {"web02"},
+		"cpu_x86_64": {"web01"},
+		"role_nginx": {"web01"},
+		"role_php":   {"web01"},
+	}
+	for group, hosts := range expected {
+		if !reflect.DeepEqual(output.Group[group].Hosts, hosts) {
+			t.Errorf("expected %v in %s, got %v", hosts, group, output.Group[group].Hosts)
+		}
+	}
+}
+
+func TestConstructInvalidExpression(t *testing.T) {
+	var output Output
+	output.Meta.HostVars = map[string]map[string]interface{}{"web01": {}}
+	var Config Configuration
+	Config.Compose = map[string]*ComposedVar{"fqdn": {Expression: "{{ .hostname "}}
+	_, err := output.Construct(Config)
+	if ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+// The doctor command checks the whole setup of a2a and prints a pass/fail report.
+// Most problems are misconfigurations which show up as errors in unrelated code.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+)
+
+// The status of a check.
+const (
+	CheckPass = "PASS"
+	CheckWarn = "WARN"
+	CheckFail = "FAIL"
+	CheckSkip = "SKIP"
+)
+
+// Check is the result of a single doctor check.
+type Check struct {
+	Name   string
+	Status string
+	Detail string
+	err    error
+}
+
+// passCheck, warnCheck, failCheck and skipCheck create the results of the checks.
+func passCheck(name string, format string, args ...interface{}) Check {
+	return Check{Name: name, Status: CheckPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func warnCheck(name string, format string, args ...interface{}) Check {
+	return Check{Name: name, Status: CheckWarn, Detail: fmt.Sprintf(format, args...)}
+}
+
+func failCheck(name string, err error) Check {
+	return Check{Name: name, Status: CheckFail, Detail: err.Error(), err: err}
+}
+
+func skipCheck(name string, format string, args ...interface{}) Check {
+	return Check{Name: name, Status: CheckSkip, Detail: fmt.Sprintf(format, args...)}
+}
+
+// RunDoctor runs all the checks. The checks that need a working configuration or API
+// token are skipped when these fail.
+func RunDoctor(timeout string) (checks []Check) {
+	Config, sources, err := LoadConfig()
+	if err != nil {
+		checks = append(checks, failCheck("config", err))
+		checks = append(checks, checkCacheDir())
+		return checks
+	}
+	if sources.Profile != "" {
+		checks = append(checks, passCheck("config", "read from %s with profile %s", sources.Path, sources.Profile))
+	} else {
+		checks = append(checks, passCheck("config", "read from %s", sources.Path))
+	}
+	checks = append(checks, checkWrappers(Config)...)
+
+	p, err := CreateConduit(Config)
+	if err != nil {
+		checks = append(checks, failCheck("conduit", err))
+		return append(checks, checkCacheDir(), checkPlaybook(Config))
+	}
+	if timeout == "" {
+		timeout = Config.Phabricator.Timeout
+	}
+	ctx, cancel, err := CreateContext(timeout)
+	defer cancel()
+	if err != nil {
+		checks = append(checks, failCheck("conduit", err))
+		return append(checks, checkCacheDir(), checkPlaybook(Config))
+	}
+	checks = append(checks, checkConduit(ctx, p)...)
+	return append(checks, checkCacheDir(), checkPlaybook(Config))
+}
+
+// checkWrappers checks that the wrapper regular expressions compile and that the
+// passphrase wrapper has the name capture group.
+func checkWrappers(Config Configuration) (checks []Check) {
+	passphraseRegex, err := regexp.Compile(Config.Wrapper.Passphrase)
+	switch {
+	case Config.Wrapper.Passphrase == "":
+		checks = append(checks, failCheck("wrapper passphrase", NewError(ConfigError, nil, "Wrapper.Passphrase is not set")))
+	case err != nil:
+		checks = append(checks, failCheck("wrapper passphrase", NewError(ConfigError, err, "Wrapper.Passphrase does not compile")))
+	case passphraseRegex.SubexpIndex("name") < 1:
+		checks = append(checks, failCheck("wrapper passphrase", NewError(ConfigError, nil, "Wrapper.Passphrase has no name capture group (?P<name>...)")))
+	default:
+		checks = append(checks, passCheck("wrapper passphrase", "%s", Config.Wrapper.Passphrase))
+	}
+	_, err = regexp.Compile(Config.Wrapper.Json)
+	switch {
+	case Config.Wrapper.Json == "":
+		checks = append(checks, failCheck("wrapper json", NewError(ConfigError, nil, "Wrapper.Json is not set")))
+	case err != nil:
+		checks = append(checks, failCheck("wrapper json", NewError(ConfigError, err, "Wrapper.Json does not compile")))
+	default:
+		checks = append(checks, passCheck("wrapper json", "%s", Config.Wrapper.Json))
+	}
+	return checks
+}
+
+// checkConduit checks the API token and the access to Almanac and Passphrase.
+func checkConduit(ctx context.Context, p *Conduit) (checks []Check) {
+	var whoami struct {
+		UserName string `json:"userName"`
+	}
+	err := p.Read(ctx, "user.whoami", "token", nil, &whoami)
+	if err != nil {
+		checks = append(checks, failCheck("api token", err))
+		for _, name := range []string{"almanac services", "almanac devices", "passphrase"} {
+			checks = append(checks, skipCheck(name, "the API token does not work"))
+		}
+		return checks
+	}
+	checks = append(checks, passCheck("api token", "authenticated as %s", whoami.UserName))
+
+	searches := []struct {
+		name   string
+		method string
+	}{
+		{"almanac services", "almanac.service.search"},
+		{"almanac devices", "almanac.device.search"},
+	}
+	for _, search := range searches {
+		var result struct {
+			Data []struct{} `json:"data"`
+		}
+		err = p.Read(ctx, search.method, search.name, map[string]interface{}{"limit": 1}, &result)
+		switch {
+		case err != nil:
+			checks = append(checks, failCheck(search.name, err))
+		case len(result.Data) == 0:
+			checks = append(checks, warnCheck(search.name, "reachable, but nothing is visible for this user"))
+		default:
+			checks = append(checks, passCheck(search.name, "reachable"))
+		}
+	}
+	// a2a reads the secrets of the credentials, so the check needs them too.
+	err = p.Read(ctx, "passphrase.query", "passphrase", map[string]interface{}{"limit": 1, "needSecrets": true}, nil)
+	if err != nil {
+		checks = append(checks, failCheck("passphrase", err))
+	} else {
+		checks = append(checks, passCheck("passphrase", "reachable"))
+	}
+	return checks
+}
+
+// checkCacheDir checks that the cache can be written to the temp folder.
+func checkCacheDir() Check {
+	file, err := ioutil.TempFile(os.TempDir(), "a2a_doctor")
+	if err != nil {
+		return failCheck("cache dir", NewError(OutputError, err, "can not write to %s", os.TempDir()))
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return passCheck("cache dir", "%s is writable", os.TempDir())
+}
+
+// checkPlaybook checks that the playbook of the vagrant mode can be parsed.
+func checkPlaybook(Config Configuration) Check {
+	if Config.Ansible.Playbook == "" {
+		return skipCheck("vagrant playbook", "Ansible.Playbook is not set")
+	}
+	playbook, err := ReadAnsiblePlayBook(Config.Ansible.Playbook)
+	if err != nil {
+		return failCheck("vagrant playbook", err)
+	}
+	if len(playbook) == 0 {
+		return failCheck("vagrant playbook", NewError(ConfigError, nil, "the playbook %s contains no plays", Config.Ansible.Playbook))
+	}
+	return passCheck("vagrant playbook", "%s with %d plays", Config.Ansible.Playbook, len(playbook))
+}
+
+// PrintChecks writes the report of the checks to w and returns the first failed check as error.
+func PrintChecks(w io.Writer, checks []Check) error {
+	var failed []Check
+	for _, check := range checks {
+		fmt.Fprintf(w, "[%s] %s: %s\n", check.Status, check.Name, check.Detail)
+		if check.Status == CheckFail {
+			failed = append(failed, check)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	kind := KindOf(failed[0].err)
+	if kind == InternalError {
+		kind = ConfigError
+	}
+	return NewError(kind, nil, "%d of %d checks failed, the first is %s", len(failed), len(checks), failed[0].Name)
+}
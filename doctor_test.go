@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestCheckWrappers(t *testing.T) {
+	var Config Configuration
+	Config.Wrapper.Passphrase = testPassphraseWrapper
+	Config.Wrapper.Json = testJsonWrapper
+	for _, check := range checkWrappers(Config) {
+		if check.Status != CheckPass {
+			t.Errorf("expected %s to pass, got %v", check.Name, check)
+		}
+	}
+
+	Config.Wrapper.Passphrase = "^\\((K[0-9]+)\\)$"
+	Config.Wrapper.Json = "^(\\[.*"
+	for _, check := range checkWrappers(Config) {
+		if check.Status != CheckFail {
+			t.Errorf("expected %s to fail, got %v", check.Name, check)
+		}
+	}
+}
+
+func TestPassphraseNameGroup(t *testing.T) {
+	// The wrapper passes the doctor, so the name group is used even when it is not the first one.
+	wrapper := regexp.MustCompile(`^(secret:)?\((?P<name>K[0-9]+)\)$`)
+	var Config Configuration
+	Config.Wrapper.Passphrase = wrapper.String()
+	if check := checkWrappers(Config)[0]; check.Status != CheckPass {
+		t.Errorf("expected the wrapper to pass, got %v", check)
+	}
+	for _, value := range []string{"(K42)", "secret:(K42)"} {
+		if name, found := passphraseName(wrapper, value); !found || name != "K42" {
+			t.Errorf("expected K42 for %s, got %q", value, name)
+		}
+	}
+}
+
+func TestPrintChecks(t *testing.T) {
+	var buffer bytes.Buffer
+	err := PrintChecks(&buffer, []Check{
+		passCheck("config", "read from %s", "config"),
+		failCheck("api token", &ConduitError{Method: "user.whoami", Code: "ERR-INVALID-AUTH"}),
+	})
+	if ExitCode(err) != exitCodes[AuthError] {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+	if buffer.String() != "[PASS] config: read from config\n[FAIL] api token: conduit user.whoami: ERR-INVALID-AUTH: \n" {
+		t.Errorf("unexpected report %q", buffer.String())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+// The errors of the application are sorted in categories. Every category has its own
+// exit code, so the scripts calling a2a can react on them. Ansible shows the stderr
+// of the inventory script, therefore only a one line message is written there.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// ErrorKind is the category of an error.
+type ErrorKind int
+
+// The categories of the errors, the exit code of every category is given in exitCodes.
+const (
+	InternalError ErrorKind = iota
+	ConfigError
+	AuthError
+	NetworkError
+	DataError
+	OutputError
+)
+
+var errorNames = map[ErrorKind]string{
+	InternalError: "internal error",
+	ConfigError:   "configuration error",
+	AuthError:     "authentication error",
+	NetworkError:  "network error",
+	DataError:     "data validation error",
+	OutputError:   "output error",
+}
+
+var exitCodes = map[ErrorKind]int{
+	InternalError: 1,
+	ConfigError:   3,
+	AuthError:     4,
+	NetworkError:  5,
+	DataError:     6,
+	OutputError:   7,
+}
+
+// exitInterrupted is used when the user has cancelled the run with Ctrl-C.
+const exitInterrupted = 130
+
+// Error is an error with a category and a message for the user.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError creates a new error of the given category.
+func NewError(kind ErrorKind, err error, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// KindOf returns the category of the given error. The Conduit errors are sorted in
+// authentication and network errors.
+func KindOf(err error) ErrorKind {
+	var a2aError *Error
+	if errors.As(err, &a2aError) && a2aError.Kind != InternalError {
+		return a2aError.Kind
+	}
+	var conduitError *ConduitError
+	if errors.As(err, &conduitError) {
+		switch {
+		case conduitError.Code == "ERR-INVALID-AUTH" || conduitError.Code == "ERR-INVALID-SESSION":
+			return AuthError
+		case conduitError.Status == http.StatusUnauthorized || conduitError.Status == http.StatusForbidden:
+			return AuthError
+		}
+		return NetworkError
+	}
+	// The overall deadline of the Conduit calls ran out.
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NetworkError
+	}
+	return InternalError
+}
+
+// ExitCode returns the exit code for the given error.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return exitCodes[KindOf(err)]
+}
+
+// PrintError writes the one line message for the error to w. In debug mode every
+// wrapped error is written in its own line.
+func PrintError(w io.Writer, err error, debug bool) {
+	fmt.Fprintf(w, "a2a: %s: %v\n", errorNames[KindOf(err)], err)
+	if !debug {
+		return
+	}
+	for cause := err; cause != nil; cause = errors.Unwrap(cause) {
+		fmt.Fprintf(w, "  %T: %v\n", cause, cause)
+	}
+}
+
+// exitWithError prints the error to stderr and stops the application with the exit
+// code of its category. Nothing is done when there is no error.
+func exitWithError(err error, debug bool) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "a2a: interrupted")
+		os.Exit(exitInterrupted)
+	}
+	PrintError(os.Stderr, err, debug)
+	os.Exit(ExitCode(err))
+}
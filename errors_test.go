@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := map[int]error{
+		0: nil,
+		1: errors.New("unexpected"),
+		3: NewError(ConfigError, nil, "no config"),
+		4: &ConduitError{Method: "user.whoami", Code: "ERR-INVALID-AUTH"},
+		5: fmt.Errorf("list: %w", &ConduitError{Method: "almanac.service.search", Status: 502}),
+		6: NewError(DataError, errors.New("unexpected end of JSON input"), "invalid prometheus-config"),
+		7: NewError(OutputError, nil, "can not write the cache"),
+	}
+	for code, err := range cases {
+		if ExitCode(err) != code {
+			t.Errorf("expected exit code %d for %v, got %d", code, err, ExitCode(err))
+		}
+	}
+}
+
+func TestDeadlineIsNetworkError(t *testing.T) {
+	err := NewError(NetworkError, context.DeadlineExceeded, "profile prod")
+	if ExitCode(err) != exitCodes[NetworkError] || ExitCode(fmt.Errorf("lint: %w", context.DeadlineExceeded)) != exitCodes[NetworkError] {
+		t.Errorf("expected the network exit code for the deadline, got %d", ExitCode(err))
+	}
+}
+
+func TestPrintErrorIsOneLine(t *testing.T) {
+	var buffer bytes.Buffer
+	err := NewError(DataError, errors.New("unexpected end of JSON input"), "invalid prometheus-config")
+	PrintError(&buffer, err, false)
+	if strings.Count(buffer.String(), "\n") != 1 {
+		t.Errorf("expected one line, got %q", buffer.String())
+	}
+	if !strings.HasPrefix(buffer.String(), "a2a: data validation error: ") {
+		t.Errorf("unexpected message %q", buffer.String())
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+// a2a explain shows where the variables of a host come from: the properties of the services of
+// its groups, the properties of its device and its bindings, the overlays, the status label, the
+// expanded templates and the composed variables. Like in Ansible the host variables win over the
+// group variables and the groups are merged in alphabetical order, so the last source of a
+// variable wins.
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// redactedValue replaces the passphrases in the explanation.
+const redactedValue = "<redacted>"
+
+// VarSource is one value of a host variable and where it comes from.
+type VarSource struct {
+	Var    string      `json:"var"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+	// Decoded is json or passphrase with the monogram, when the property was decoded.
+	Decoded string `json:"decoded,omitempty"`
+	// Winner is set for the value that is used by Ansible.
+	Winner bool `json:"winner"`
+}
+
+// Explain returns the sources of the variables of the host from the lowest to the highest
+// precedence. The output is the inventory from Almanac before it is augmented. The inventory
+// is built like a2a inventory list does: the status action, the overlays, the templates and the
+// composed variables of the configuration are applied. The passphrases are not resolved.
+func (output *Output) Explain(host string, Config Configuration) (sources []VarSource, err error) {
+	if _, found := output.Meta.HostVars[host]; !found {
+		return sources, NewError(DataError, nil, "the host %s is not in the inventory", host)
+	}
+	status, err := GetStatusOptions(Config, "inventory")
+	if err != nil {
+		return sources, err
+	}
+	overlays := make([]OverlayFile, len(Config.Overlay.File))
+	for i, path := range Config.Overlay.File {
+		overlays[i], err = ReadOverlay(path)
+		if err != nil {
+			return sources, err
+		}
+	}
+	add := func(vars map[string]interface{}, prefix string, source string) {
+		for _, key := range sortedKeys(vars) {
+			value, decoded := explainValue(normalizeYaml(vars[key]), Config.Wrapper.Passphrase, Config.Wrapper.Json)
+			sources = append(sources, VarSource{Var: prefix + key, Value: value, Source: source, Decoded: decoded})
+		}
+	}
+
+	// The final inventory gives the groups of the host and the values that Ansible gets.
+	final := output.clone()
+	final.ApplyStatus(status)
+	labeled := final.Meta.HostVars[host][statusVar]
+	for _, overlay := range overlays {
+		final.ApplyOverlay(overlay)
+	}
+	if _, found := final.Meta.HostVars[host]; !found {
+		return sources, NewError(DataError, nil, "the host %s is inactive and dropped from the inventory, see [Status]", host)
+	}
+	for _, vars := range final.Meta.HostVars {
+		decodeVars(vars, Config.Wrapper.Passphrase, Config.Wrapper.Json)
+	}
+	for _, group := range final.Group {
+		decodeVars(group.Vars, Config.Wrapper.Passphrase, Config.Wrapper.Json)
+	}
+	raw := final.varsOf(host)
+	for _, problem := range final.ExpandTemplates(Config) {
+		if problem.Device == host {
+			logger.Warn("the templated variable is removed", "error", problem.Error())
+		}
+	}
+	before := make(map[string]interface{})
+	for key, value := range final.Meta.HostVars[host] {
+		before[key] = value
+	}
+	_, err = final.Construct(Config)
+	if err != nil {
+		return sources, err
+	}
+
+	for _, name := range final.GroupNames() {
+		if !containsString(final.Group[name].Hosts, host) {
+			continue
+		}
+		add(output.Group[name].Vars, "", "service "+name)
+		for i, overlay := range overlays {
+			add(overlay.Groups[name].Vars, "", "overlay "+Config.Overlay.File[i]+" group "+name)
+		}
+	}
+
+	flattened := make(map[string]bool)
+	if Config.Bindings.Flatten {
+		for _, name := range output.GroupNames() {
+			for key := range output.Group[name].Bindings[host] {
+				flattened[key] = true
+			}
+		}
+	}
+	device := make(map[string]interface{})
+	for key, value := range output.Meta.HostVars[host] {
+		if key != bindingsVar && key != sourceVar && !flattened[key] {
+			device[key] = value
+		}
+	}
+	add(device, "", "device "+host)
+	bindings, _ := output.Meta.HostVars[host][bindingsVar].(map[string]interface{})
+	for _, name := range sortedKeys(bindings) {
+		vars, _ := bindings[name].(map[string]interface{})
+		add(vars, bindingsVar+"."+name+".", "binding "+name)
+	}
+	if Config.Bindings.Flatten {
+		for _, name := range output.GroupNames() {
+			add(output.Group[name].Bindings[host], "", "binding "+name)
+		}
+	}
+	if profile, found := output.Meta.HostVars[host][sourceVar]; found {
+		add(map[string]interface{}{sourceVar: profile}, "", "merge")
+	}
+	for i, overlay := range overlays {
+		add(overlay.Hosts[host], "", "overlay "+Config.Overlay.File[i])
+	}
+	if _, found := output.Meta.HostVars[host][statusVar]; !found && labeled != nil {
+		sources = append(sources, VarSource{Var: statusVar, Value: labeled, Source: "status " + status.Action})
+	}
+	for _, name := range sortedKeys(before) {
+		if text, ok := raw[name].(string); ok && Config.Template.Enabled && strings.Contains(text, Config.Template.Left) {
+			sources = append(sources, VarSource{Var: name, Value: before[name], Source: "template", Decoded: "expanded"})
+		}
+	}
+	for _, name := range sortedKeys(Config.Compose) {
+		value, found := final.Meta.HostVars[host][name]
+		if found && (before[name] == nil || fmt.Sprint(before[name]) != fmt.Sprint(value)) {
+			sources = append(sources, VarSource{Var: name, Value: value, Source: "compose " + name})
+		}
+	}
+
+	winners := make(map[string]int)
+	for i, source := range sources {
+		winners[source.Var] = i
+	}
+	for _, i := range winners {
+		sources[i].Winner = true
+	}
+	return sources, nil
+}
+
+// explainValue returns the decoded value of a property and how it was decoded. The passphrases
+// are replaced with redactedValue.
+func explainValue(value interface{}, passphraseWrapper string, jsonWrapper string) (interface{}, string) {
+	text, ok := value.(string)
+	if !ok {
+		return value, ""
+	}
+	if passphraseWrapper != "" {
+		if monogram, found := passphraseName(regexp.MustCompile(passphraseWrapper), text); found {
+			return redactedValue, "passphrase " + monogram
+		}
+	}
+	if jsonWrapper != "" {
+		decoded, isJson, err := HandleJson(jsonWrapper, text)
+		if isJson && err == nil {
+			return decoded, "json"
+		}
+	}
+	return value, ""
+}
+
+// decodeVars decodes the json properties and redacts the passphrases of the variables.
+func decodeVars(vars map[string]interface{}, passphraseWrapper string, jsonWrapper string) {
+	for key, value := range vars {
+		vars[key], _ = explainValue(value, passphraseWrapper, jsonWrapper)
+	}
+}
+
+// clone returns a copy of the inventory, whose groups and variables can be changed.
+func (output *Output) clone() (copied Output) {
+	copied.Group = make(map[string]Group, len(output.Group))
+	for name, group := range output.Group {
+		group.Hosts = append([]string{}, group.Hosts...)
+		// ApplyStatus labels the hosts in the status of the group.
+		status := make(map[string]string, len(group.Status))
+		for host, value := range group.Status {
+			status[host] = value
+		}
+		group.Status = status
+		vars := make(map[string]interface{}, len(group.Vars))
+		for key, value := range group.Vars {
+			vars[key] = value
+		}
+		group.Vars = vars
+		copied.Group[name] = group
+	}
+	copied.Meta.HostVars = make(map[string]map[string]interface{}, len(output.Meta.HostVars))
+	for host, hostVars := range output.Meta.HostVars {
+		vars := make(map[string]interface{}, len(hostVars))
+		for key, value := range hostVars {
+			vars[key] = value
+		}
+		copied.Meta.HostVars[host] = vars
+	}
+	return copied
+}
+
+// FilterSources returns the sources of the given variable, the parts of a json variable are included.
+func FilterSources(sources []VarSource, name string) (filtered []VarSource, err error) {
+	for _, source := range sources {
+		if source.Var == name || strings.HasPrefix(source.Var, name+".") {
+			filtered = append(filtered, source)
+		}
+	}
+	if len(filtered) == 0 {
+		return filtered, NewError(DataError, nil, "the variable %s has no source", name)
+	}
+	return filtered, nil
+}
+
+// PrintExplanation prints the sources of every variable, the winner is marked with a star and
+// printed first.
+func PrintExplanation(w io.Writer, sources []VarSource) {
+	byVar := make(map[string][]VarSource)
+	for _, source := range sources {
+		byVar[source.Var] = append([]VarSource{source}, byVar[source.Var]...)
+	}
+	for _, key := range sortedKeys(byVar) {
+		fmt.Fprintln(w, key)
+		for _, source := range byVar[key] {
+			marker := " "
+			if source.Winner {
+				marker = "*"
+			}
+			decoded := ""
+			if source.Decoded != "" {
+				decoded = " (" + source.Decoded + ")"
+			}
+			fmt.Fprintf(w, "  %s %s: %s%s\n", marker, source.Source, jsonText(source.Value), decoded)
+		}
+	}
+}
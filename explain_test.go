@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	var output Output
+	output.Group = map[string]Group{
+		"mysql-servers": {Hosts: []string{"db01"}, Vars: map[string]interface{}{"db_port": "5432", "db_password": "(K42)"}},
+		"backup":        {Hosts: []string{"db01"}, Vars: map[string]interface{}{"db_port": "5434", "targets": `["a"]`}},
+	}
+	output.Meta.HostVars = map[string]map[string]interface{}{
+		"db01": {"db_port": "5433", bindingsVar: map[string]interface{}{"mysql_servers": map[string]interface{}{"replica_id": "2"}}},
+	}
+	overlay := filepath.Join(t.TempDir(), "overlay.yml")
+	if err := ioutil.WriteFile(overlay, []byte("groups:\n  backup:\n    vars:\n      db_port: 5435\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	Config := defaultConfig()
+	Config.Wrapper.Passphrase = testPassphraseWrapper
+	Config.Wrapper.Json = testJsonWrapper
+	Config.Overlay.File = []string{overlay}
+	Config.Compose = map[string]*ComposedVar{"dsn": {Expression: "db01:{{ .db_port }}"}}
+	sources, err := output.Explain("db01", Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ports, err := FilterSources(sources, "db_port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, source := range ports {
+		order = append(order, source.Source)
+	}
+	expected := []string{"service backup", "overlay " + overlay + " group backup", "service mysql-servers", "device db01"}
+	if !reflect.DeepEqual(order, expected) || !ports[3].Winner || ports[0].Winner {
+		t.Errorf("unexpected sources of db_port %v", ports)
+	}
+
+	var text bytes.Buffer
+	PrintExplanation(&text, sources)
+	for _, line := range []string{
+		"  * service mysql-servers: \"<redacted>\" (passphrase K42)",
+		"  * service backup: [\"a\"] (json)",
+		"a2a_bindings.mysql_servers.replica_id\n  * binding mysql_servers: \"2\"",
+		"dsn\n  * compose dsn: \"db01:5433\"",
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("expected %q in the explanation:\n%s", line, text.String())
+		}
+	}
+
+	_, err = FilterSources(sources, "missing")
+	if ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data error, got %v", err)
+	}
+	_, err = output.Explain("web01", Config)
+	if ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data error for an unknown host, got %v", err)
+	}
+}
+
+func TestExplainTemplatesAndStatus(t *testing.T) {
+	var output Output
+	output.Group = map[string]Group{
+		"web": {Hosts: []string{"web01", "web02"}, Vars: map[string]interface{}{"backup_target": "{{ .host.name }}.backup"}},
+	}
+	output.Meta.HostVars = map[string]map[string]interface{}{
+		"web01": {},
+		"web02": {"a2a_status": "decommissioned"},
+	}
+	Config := defaultConfig()
+	Config.Template.Enabled = true
+	sources, err := output.Explain("web01", Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := FilterSources(sources, "backup_target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[1].Source != "template" || targets[1].Value != "web01.backup" || !targets[1].Winner {
+		t.Errorf("expected the expanded template to win, got %v", targets)
+	}
+	if _, err = output.Explain("web02", Config); ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data error for a dropped host, got %v", err)
+	}
+
+	Config.Status.Inventory = StatusLabel
+	sources, err = output.Explain("web01", Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FilterSources(sources, statusVar); err == nil {
+		t.Errorf("an active host should have no status label, got %v", sources)
+	}
+	output.Meta.HostVars["web02"] = map[string]interface{}{}
+	web := output.Group["web"]
+	web.Status = map[string]string{"web02": statusDisabled}
+	output.Group["web"] = web
+	sources, err = output.Explain("web02", Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, err := FilterSources(sources, statusVar)
+	if err != nil || labels[0].Source != "status label" || labels[0].Value != statusDisabled {
+		t.Errorf("expected the status label, got %v %v", labels, err)
+	}
+	if len(output.Meta.HostVars["web02"]) != 0 {
+		t.Errorf("the inventory should not be changed, got %v", output.Meta.HostVars["web02"])
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+// a2a facts sync writes facts from the Ansible fact cache to the Almanac devices, so Almanac
+// shows the OS, kernel or memory of the hosts without anybody keeping them up to date. The
+// cache files are named by the inventory hosts, which are mapped back to their devices.
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FactSource is a [Fact "name"] section of the configuration, the name is the property key without the prefix.
+type FactSource struct {
+	// Path is the fact, ex. ansible_default_ipv4.macaddress for a value in a dictionary.
+	Path string
+}
+
+// defaultFacts are the facts written when the configuration has no Fact sections.
+var defaultFacts = map[string]string{
+	"os":          "ansible_distribution",
+	"os-release":  "ansible_distribution_version",
+	"kernel":      "ansible_kernel",
+	"cpu-count":   "ansible_processor_vcpus",
+	"memory-mb":   "ansible_memtotal_mb",
+	"primary-mac": "ansible_default_ipv4.macaddress",
+}
+
+// FactUpdate are the changed facts of one device.
+type FactUpdate struct {
+	Host    string
+	Target  PropertyTarget
+	Conduit *Conduit
+	Values  map[string]string
+	Diff    HostDiff
+}
+
+// FactPaths returns the facts by the property key.
+func FactPaths(Config Configuration) map[string]string {
+	paths := make(map[string]string)
+	for name, fact := range Config.Fact {
+		if fact != nil && fact.Path != "" {
+			paths[Config.Facts.Prefix+name] = fact.Path
+		}
+	}
+	if len(paths) > 0 {
+		return paths
+	}
+	for name, path := range defaultFacts {
+		paths[Config.Facts.Prefix+name] = path
+	}
+	return paths
+}
+
+// ReadFactCache reads the cached facts of the host, found is false when the cache has no file for it.
+func ReadFactCache(dir string, host string) (facts map[string]interface{}, found bool, err error) {
+	file, err := os.Open(filepath.Join(dir, host))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, NewError(ConfigError, err, "can not read the facts of %s", host)
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	// The numbers are kept as they are, so 16777216 does not become 1.6777216e+07.
+	decoder.UseNumber()
+	if err = decoder.Decode(&facts); err != nil {
+		return nil, false, NewError(DataError, err, "invalid facts of %s", host)
+	}
+	// Some Ansible versions cache the facts below ansible_facts and without the ansible_ prefix.
+	if nested, ok := facts["ansible_facts"].(map[string]interface{}); ok {
+		for key, value := range nested {
+			facts[key] = value
+		}
+	}
+	return facts, true, nil
+}
+
+// FactValues returns the text of the facts by the property key, missing facts are left out.
+func FactValues(facts map[string]interface{}, paths map[string]string) map[string]string {
+	values := make(map[string]string)
+	for key, path := range paths {
+		value, found := lookupVar(facts, path)
+		if !found && strings.HasPrefix(path, "ansible_") {
+			value, found = lookupVar(facts, strings.TrimPrefix(path, "ansible_"))
+		}
+		if !found || value == nil {
+			continue
+		}
+		text, err := importValue(value)
+		if err == nil && text != "" {
+			values[key] = text
+		}
+	}
+	return values
+}
+
+// PlanFactSync compares the cached facts of the inventory hosts with the properties of their
+// devices. The hosts of merged profiles are looked up in their profile, renamed hosts with
+// their name in Almanac. Hosts without cached facts or without a device are skipped.
+func PlanFactSync(ctx context.Context, sources []InventorySource, output Output, Config Configuration) (updates []FactUpdate, err error) {
+	if Config.Facts.Directory == "" {
+		return updates, NewError(ConfigError, nil, "the fact cache is not set, use --directory or Facts.Directory")
+	}
+	if _, err = os.Stat(Config.Facts.Directory); err != nil {
+		return updates, NewError(ConfigError, err, "can not read the fact cache")
+	}
+	paths := FactPaths(Config)
+	hosts := make([]string, 0, len(output.Meta.HostVars))
+	for host := range output.Meta.HostVars {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	// candidates are the possible device names of every host per source.
+	facts := make(map[string]map[string]interface{})
+	candidates := make(map[int]map[string][]string)
+	for _, host := range hosts {
+		hostFacts, found, err := ReadFactCache(Config.Facts.Directory, host)
+		if err != nil {
+			return updates, err
+		}
+		if !found {
+			logger.Debug("no cached facts", "host", host)
+			continue
+		}
+		facts[host] = hostFacts
+		index, names := 0, []string{host}
+		if name, ok := output.Meta.HostVars[host][sourceVar].(string); ok {
+			for i, source := range sources {
+				if source.Name == name {
+					index = i
+				}
+			}
+			if renamed := strings.TrimPrefix(host, name+"-"); renamed != host {
+				names = append(names, renamed)
+			}
+		}
+		if candidates[index] == nil {
+			candidates[index] = make(map[string][]string)
+		}
+		candidates[index][host] = names
+	}
+
+	for index, source := range sources {
+		if len(candidates[index]) == 0 {
+			continue
+		}
+		var names []string
+		for _, hostNames := range candidates[index] {
+			names = append(names, hostNames...)
+		}
+		sort.Strings(names)
+		devices, err := source.Conduit.GetDevices(ctx, names, map[string]bool{"properties": true})
+		if err != nil {
+			return updates, err
+		}
+		byName := make(map[string]Device, len(devices))
+		for _, device := range devices {
+			byName[device.Fields.Name] = device
+		}
+		for _, host := range sortedKeys(candidates[index]) {
+			var device *Device
+			for _, name := range candidates[index][host] {
+				if found, ok := byName[name]; ok {
+					device = &found
+					break
+				}
+			}
+			if device == nil {
+				logger.Info("host has no Almanac device, its facts are skipped", "host", host)
+				continue
+			}
+			target := PropertyTarget{Kind: "device", Name: device.Fields.Name, PHID: device.PHID, Properties: device.Attachments.Properties.Properties}
+			if update, changed := factUpdate(host, target, FactValues(facts[host], paths)); changed {
+				update.Conduit = source.Conduit
+				updates = append(updates, update)
+			}
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Host < updates[j].Host })
+	return updates, nil
+}
+
+// factUpdate returns the facts that differ from the properties of the device.
+func factUpdate(host string, target PropertyTarget, values map[string]string) (update FactUpdate, changed bool) {
+	update = FactUpdate{Host: host, Target: target, Values: make(map[string]string)}
+	update.Diff = HostDiff{Name: host, Change: "~"}
+	for _, key := range sortedKeys(values) {
+		old, found := target.Value(key)
+		switch {
+		case !found:
+			update.Diff.Vars = append(update.Diff.Vars, VarChange{Change: "+", Var: target.Key(key), New: values[key]})
+		case old != values[key]:
+			update.Diff.Vars = append(update.Diff.Vars, VarChange{Change: "~", Var: target.Key(key), Old: old, New: values[key]})
+		default:
+			continue
+		}
+		update.Values[key] = values[key]
+	}
+	return update, len(update.Values) > 0
+}
+
+// FactDiff returns the changes of all the updates.
+func FactDiff(updates []FactUpdate) (diff InventoryDiff) {
+	for _, update := range updates {
+		diff.Hosts = append(diff.Hosts, update.Diff)
+	}
+	return diff
+}
+
+// ApplyFactSync writes the changed facts to the devices.
+func ApplyFactSync(ctx context.Context, updates []FactUpdate) error {
+	for _, update := range updates {
+		if err := update.Target.Set(ctx, update.Conduit, update.Values); err != nil {
+			return err
+		}
+		logger.Info("facts written", "host", update.Host, "device", update.Target.Name, "count", len(update.Values))
+	}
+	return nil
+}
+
+// confirm asks the question and returns true when it is answered with yes.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFactValues(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "web01"), []byte(`{"ansible_distribution":"Debian","ansible_kernel":"6.1.0",
+		"ansible_memtotal_mb":16777216,"ansible_default_ipv4":{"macaddress":"52:54:00:12:34:56"}}`), 0600)
+	os.WriteFile(filepath.Join(dir, "db01"), []byte(`{"ansible_facts":{"distribution":"Ubuntu","processor_vcpus":4}}`), 0600)
+	os.WriteFile(filepath.Join(dir, "broken"), []byte(`{`), 0600)
+
+	Config := defaultConfig()
+	facts, found, err := ReadFactCache(dir, "web01")
+	if err != nil || !found {
+		t.Fatal(found, err)
+	}
+	expected := map[string]string{
+		"fact-os":          "Debian",
+		"fact-kernel":      "6.1.0",
+		"fact-memory-mb":   "16777216",
+		"fact-primary-mac": "52:54:00:12:34:56",
+	}
+	if values := FactValues(facts, FactPaths(Config)); !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	facts, _, _ = ReadFactCache(dir, "db01")
+	Config.Fact = map[string]*FactSource{"cpus": {Path: "ansible_processor_vcpus"}}
+	if values := FactValues(facts, FactPaths(Config)); !reflect.DeepEqual(values, map[string]string{"fact-cpus": "4"}) {
+		t.Errorf("unexpected values of the configured facts %v", values)
+	}
+
+	if _, found, err = ReadFactCache(dir, "missing"); found || err != nil {
+		t.Errorf("a host without facts should be skipped, got %v %v", found, err)
+	}
+	if _, _, err = ReadFactCache(dir, "broken"); ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data error, got %v", err)
+	}
+}
+
+func TestFactSync(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "web01"), []byte(`{"ansible_distribution":"Debian","ansible_kernel":"6.1.0"}`), 0600)
+	os.WriteFile(filepath.Join(dir, "db01"), []byte(`{"ansible_distribution":"Debian"}`), 0600)
+	responses := map[string]string{
+		"almanac.device.search": `{"data":[{"phid":"PHID-ADEV-1","fields":{"name":"web01"},"attachments":{"properties":{"properties":[
+			{"key":"fact_os","value":"Ubuntu"},{"key":"fact-kernel","value":"6.1.0"}]}}}],"cursor":{"after":null}}`,
+	}
+	var edits []map[string]interface{}
+	server := editServer(t, responses, &edits)
+	defer server.Close()
+	p := NewConduit(server.URL, "api-token")
+	Config := defaultConfig()
+	var output Output
+	output.Meta.HostVars = map[string]map[string]interface{}{"web01": {}, "db01": {}, "app01": {}}
+	sources := []InventorySource{{Config: Config, Conduit: p}}
+
+	if _, err := PlanFactSync(context.Background(), sources, output, Config); ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a config error without a fact cache, got %v", err)
+	}
+	Config.Facts.Directory = dir
+	updates, err := PlanFactSync(context.Background(), sources, output, Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := InventoryDiff{Hosts: []HostDiff{{Name: "web01", Change: "~", Vars: []VarChange{{Change: "~", Var: "fact_os", Old: "Ubuntu", New: "Debian"}}}}}
+	if diff := FactDiff(updates); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("unexpected diff %v", diff)
+	}
+	var text bytes.Buffer
+	PrintDiff(&text, FactDiff(updates))
+	if !strings.Contains(text.String(), "~ host web01") {
+		t.Errorf("unexpected printed diff:\n%s", text.String())
+	}
+	if len(edits) != 0 {
+		t.Errorf("the plan should change nothing, got %v", edits)
+	}
+
+	if err = ApplyFactSync(context.Background(), updates); err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[0]["method"] != "almanac.device.edit" {
+		t.Errorf("expected one device edit, got %v", edits)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	var out bytes.Buffer
+	if !confirm(strings.NewReader("yes\n"), &out, "Write?") || out.String() != "Write? [y/N] " {
+		t.Errorf("yes should confirm, asked %q", out.String())
+	}
+	if confirm(strings.NewReader("\n"), &out, "Write?") {
+		t.Error("no answer should not confirm")
+	}
+}
@@ -25,7 +25,8 @@
 #
 # Requirements:
 #
-#   * GoLang 1.6+ (for mips and ppc), 1.5 for non-mips/ppc.
+#   * GoLang 1.21+ for a2a (log/slog and generics), built in GOPATH mode with
+#     GO111MODULE=off, as a2a has no go.mod and uses the relative import ./alertmanager/config.
 #   * CD to directory of the binary you are compiling. $PWD is used here.
 #
 # For 1.4 and earlier, see http://dave.cheney.net/2012/09/08/an-introduction-to-cross-compilation-with-go
@@ -61,6 +62,9 @@ PLATFORMS_ARM="linux"
 
 type setopt >/dev/null 2>&1
 
+# a2a has no go.mod, so it is built in GOPATH mode.
+export GO111MODULE=${GO111MODULE:-off}
+
 SCRIPT_NAME=`basename "$0"`
 FAILURES=""
 SOURCE_FILE=`echo $@ | sed 's/\.go//'`
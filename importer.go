@@ -0,0 +1,466 @@
+package main
+
+// a2a import moves a static Ansible inventory to Almanac: the groups become services, the hosts
+// devices with an interface and bindings, and the variables properties. Existing objects are
+// kept, so the import can run again after the static inventory changed.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultSecretKeys matches the variable names that look like secrets.
+const defaultSecretKeys = `(?i)(pass(word|wd)?|secret|token|private_key|api_key)`
+
+// customServiceType is the Almanac type of the services created by the import.
+const customServiceType = "almanac.custom"
+
+// The actions of the import plan.
+const (
+	ImportCreate = "create"
+	ImportSet    = "set"
+	ImportKeep   = "keep"
+	ImportSkip   = "skip"
+)
+
+// connectionVars are the variables used for the interface of a host, they are no properties.
+var connectionVars = map[string]bool{"ansible_host": true, "ansible_port": true}
+
+// ImportStep is one change of the import. In a dry run it is only planned.
+type ImportStep struct {
+	Action string `json:"action"`
+	Object string `json:"object"`
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ImportOptions are the options of the import.
+type ImportOptions struct {
+	Network string
+	DryRun  bool
+	// SecretsFile lists the secrets of the inventory, without it the import fails on secrets.
+	SecretsFile string
+}
+
+// ImportSecret is a variable that looks like a secret. Its value is never written, neither to
+// Almanac nor to the secrets file. With the monogram of its Passphrase credential the import
+// sets the reference as property.
+type ImportSecret struct {
+	Object   string `yaml:"object"`
+	Name     string `yaml:"name"`
+	Source   string `yaml:"source"`
+	Key      string `yaml:"key"`
+	Monogram string `yaml:"monogram"`
+}
+
+// secretsHeader explains the secrets file to the reviewer.
+const secretsHeader = `# The variables of the inventory that look like secrets. Create them in Passphrase, add the
+# monograms here and run a2a import again to set the references.
+`
+
+// importer keeps the Almanac objects read before the import.
+type importer struct {
+	ctx         context.Context
+	conduit     *Conduit
+	config      Configuration
+	options     ImportOptions
+	secret      *regexp.Regexp
+	passphrase  *regexp.Regexp
+	networkPHID string
+	// secrets are the secrets of the inventory by object, source and key.
+	secrets  map[string]*ImportSecret
+	services map[string]Service
+	devices  map[string]Device
+	// created are the PHIDs of the created services, they are empty in a dry run.
+	created map[string]string
+	steps   []ImportStep
+}
+
+// Import creates the services, devices, interfaces, bindings and properties of the static
+// inventory that are missing in Almanac. With DryRun nothing is changed.
+func Import(ctx context.Context, p *Conduit, Config Configuration, inventory *StaticInventory, options ImportOptions) (steps []ImportStep, err error) {
+	im := &importer{ctx: ctx, conduit: p, config: Config, options: options, created: make(map[string]string), steps: make([]ImportStep, 0)}
+	if im.secret, err = regexp.Compile(Config.Import.Secret); err != nil {
+		return im.steps, NewError(ConfigError, err, "invalid Import.Secret")
+	}
+	if im.passphrase, err = regexp.Compile(Config.Wrapper.Passphrase); err != nil {
+		return im.steps, NewError(ConfigError, err, "invalid Wrapper.Passphrase")
+	}
+	if options.Network == "" {
+		return im.steps, NewError(ConfigError, nil, "the import needs --network or Import.Network for the interfaces")
+	}
+	if err = im.findSecrets(inventory); err != nil {
+		return im.steps, err
+	}
+	if im.networkPHID, err = findNetwork(ctx, p, options.Network); err != nil {
+		return im.steps, err
+	}
+	services, err := p.GetServices(ctx)
+	if err != nil {
+		return im.steps, err
+	}
+	im.services = make(map[string]Service, len(services))
+	for _, service := range services {
+		im.services[sanitizeGroupName(service.Fields.Name)] = service
+	}
+	devices, err := p.GetAllDevices(ctx)
+	if err != nil {
+		return im.steps, err
+	}
+	im.devices = make(map[string]Device, len(devices))
+	for _, device := range devices {
+		im.devices[device.Fields.Name] = device
+	}
+
+	for _, group := range inventory.GroupNames() {
+		if err = im.group(group, inventory.Groups[group].Vars); err != nil {
+			return im.steps, err
+		}
+	}
+	for _, host := range inventory.HostNames() {
+		if err = im.host(host, inventory.Hosts[host], inventory.HostGroups(host)); err != nil {
+			return im.steps, err
+		}
+	}
+	return im.steps, nil
+}
+
+// almanacName returns the Almanac name of a group or host, Almanac names have no underscores
+// and no upper case letters.
+func almanacName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
+
+// isSecret returns true when the variable looks like a secret and is no Passphrase reference.
+func (im *importer) isSecret(key string, value string) bool {
+	return !im.passphrase.MatchString(value) && (im.secret.MatchString(key) || strings.HasPrefix(value, "$ANSIBLE_VAULT"))
+}
+
+// secretKey returns the key of a secret in importer.secrets.
+func secretKey(object string, source string, key string) string {
+	return object + " " + source + " " + key
+}
+
+// findSecrets looks for the secrets before anything is changed. Without a secrets file the import
+// fails with the list of the secrets, else the file is written with the known monograms. A dry run
+// only plans the secrets and writes no file.
+func (im *importer) findSecrets(inventory *StaticInventory) error {
+	var found []ImportSecret
+	for _, group := range inventory.GroupNames() {
+		if group == "all" || group == "ungrouped" {
+			continue
+		}
+		for _, key := range sortedKeys(inventory.Groups[group].Vars) {
+			if value, err := importValue(inventory.Groups[group].Vars[key]); err == nil && im.isSecret(key, value) {
+				found = append(found, ImportSecret{Object: "service", Name: almanacName(group), Source: group, Key: key})
+			}
+		}
+	}
+	for _, host := range inventory.HostNames() {
+		for _, key := range sortedKeys(inventory.Hosts[host]) {
+			if connectionVars[key] {
+				continue
+			}
+			if value, err := importValue(inventory.Hosts[host][key]); err == nil && im.isSecret(key, value) {
+				found = append(found, ImportSecret{Object: "device", Name: almanacName(host), Source: host, Key: key})
+			}
+		}
+	}
+	im.secrets = make(map[string]*ImportSecret, len(found))
+	if len(found) == 0 {
+		return nil
+	}
+	if im.options.SecretsFile == "" && !im.options.DryRun {
+		names := make([]string, 0, len(found))
+		for _, secret := range found {
+			names = append(names, secret.Source+" "+secret.Key)
+		}
+		return NewError(ConfigError, nil, "the inventory has secrets: %s, use --secrets-file to list them for review and import the rest",
+			strings.Join(names, ", "))
+	}
+	var known []ImportSecret
+	if im.options.SecretsFile != "" {
+		var err error
+		if known, err = ReadSecretsFile(im.options.SecretsFile); err != nil {
+			return err
+		}
+	}
+	monograms := make(map[string]string, len(known))
+	for _, secret := range known {
+		monograms[secretKey(secret.Object, secret.Source, secret.Key)] = secret.Monogram
+	}
+	for i := range found {
+		key := secretKey(found[i].Object, found[i].Source, found[i].Key)
+		found[i].Monogram = monograms[key]
+		im.secrets[key] = &found[i]
+	}
+	if im.options.DryRun {
+		return nil
+	}
+	logger.Warn("the secrets are listed in the secrets file, only the ones with a monogram are imported", "file", im.options.SecretsFile, "count", len(found))
+	return WriteSecretsFile(im.options.SecretsFile, found)
+}
+
+// ReadSecretsFile reads the secrets file of the import, a missing file has no secrets.
+func ReadSecretsFile(path string) ([]ImportSecret, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewError(ConfigError, err, "can not read the secrets file")
+	}
+	var file struct {
+		Secrets []ImportSecret `yaml:"secrets"`
+	}
+	if err = yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, NewError(ConfigError, err, "invalid secrets file %s", path)
+	}
+	return file.Secrets, nil
+}
+
+// WriteSecretsFile writes the secrets without their values, so the file can be reviewed.
+func WriteSecretsFile(path string, secrets []ImportSecret) error {
+	content, err := yaml.Marshal(map[string][]ImportSecret{"secrets": secrets})
+	if err != nil {
+		return NewError(ConfigError, err, "can not encode the secrets file")
+	}
+	if err = ioutil.WriteFile(path, append([]byte(secretsHeader), content...), 0600); err != nil {
+		return NewError(ConfigError, err, "can not write the secrets file")
+	}
+	return nil
+}
+
+// add adds a step to the plan.
+func (im *importer) add(action string, object string, name string, detail string) {
+	im.steps = append(im.steps, ImportStep{Action: action, Object: object, Name: name, Detail: detail})
+}
+
+// group creates the service of the group and sets its properties.
+func (im *importer) group(group string, vars map[string]interface{}) error {
+	if group == "all" || group == "ungrouped" {
+		if len(vars) > 0 {
+			im.add(ImportSkip, "service", group, "the variables of "+group+" have no service, use an overlay")
+		}
+		return nil
+	}
+	name := almanacName(group)
+	target := PropertyTarget{Kind: "service", Name: name}
+	if service, found := im.services[sanitizeGroupName(group)]; found {
+		target.Name, target.PHID, target.Properties = service.Fields.Name, service.PHID, service.Attachments.Properties.Properties
+		im.add(ImportKeep, "service", target.Name, "")
+	} else {
+		im.add(ImportCreate, "service", name, renamed(group, name))
+		if !im.options.DryRun {
+			phid, err := im.conduit.Edit(im.ctx, "service", "", name, []map[string]interface{}{
+				{"type": "type", "value": customServiceType},
+				{"type": "name", "value": name},
+			})
+			if err != nil {
+				return err
+			}
+			target.PHID = phid
+			im.created[group] = phid
+		}
+	}
+	return im.properties(target, vars, "service", group)
+}
+
+// host creates the device of the host with its interface and bindings and sets its properties.
+func (im *importer) host(host string, vars map[string]interface{}, groups []string) error {
+	name := almanacName(host)
+	address := host
+	if value, found := vars["ansible_host"]; found {
+		address = fmt.Sprint(value)
+	}
+	port := 22
+	if value, found := vars["ansible_port"]; found {
+		parsed, err := strconv.Atoi(fmt.Sprint(value))
+		if err != nil {
+			return NewError(DataError, err, "invalid ansible_port of host %s", host)
+		}
+		port = parsed
+	}
+	interfaceName := address + ":" + strconv.Itoa(port) + " in " + im.options.Network
+
+	target := PropertyTarget{Kind: "device", Name: name}
+	interfacePHID := ""
+	if device, found := im.devices[name]; found {
+		target.PHID, target.Properties = device.PHID, device.Attachments.Properties.Properties
+		im.add(ImportKeep, "device", name, "")
+		phid, err := findInterface(im.ctx, im.conduit, target, im.networkPHID, address, port)
+		if err != nil {
+			return err
+		}
+		interfacePHID = phid
+	} else {
+		im.add(ImportCreate, "device", name, renamed(host, name))
+		if !im.options.DryRun {
+			created, err := createDevice(im.ctx, im.conduit, name)
+			if err != nil {
+				return err
+			}
+			target.PHID = created.PHID
+		}
+	}
+	if interfacePHID != "" {
+		im.add(ImportKeep, "interface", interfaceName, "")
+	} else {
+		im.add(ImportCreate, "interface", interfaceName, "")
+		if !im.options.DryRun {
+			phid, err := createInterface(im.ctx, im.conduit, target, im.networkPHID, address, port)
+			if err != nil {
+				return err
+			}
+			interfacePHID = phid
+		}
+	}
+
+	if len(groups) == 0 {
+		im.add(ImportSkip, "binding", name, "the host is in no group, so it is not in the inventory")
+	}
+	for _, group := range groups {
+		if err := im.binding(group, name, interfacePHID); err != nil {
+			return err
+		}
+	}
+	properties := make(map[string]interface{}, len(vars))
+	for key, value := range vars {
+		if !connectionVars[key] {
+			properties[key] = value
+		}
+	}
+	return im.properties(target, properties, "device", host)
+}
+
+// binding binds the device to the service of the group, a device bound in any way is kept.
+func (im *importer) binding(group string, device string, interfacePHID string) error {
+	serviceName, servicePHID := almanacName(group), im.created[group]
+	if service, found := im.services[sanitizeGroupName(group)]; found {
+		serviceName, servicePHID = service.Fields.Name, service.PHID
+		for _, binding := range service.Attachments.Bindings.Bindings {
+			if binding.Interface.Device.Name != device {
+				continue
+			}
+			detail := ""
+			if binding.Disabled {
+				detail = "the binding is disabled"
+			}
+			im.add(ImportKeep, "binding", serviceName+" "+device, detail)
+			return nil
+		}
+	}
+	im.add(ImportCreate, "binding", serviceName+" "+device, "")
+	if im.options.DryRun {
+		return nil
+	}
+	return createBinding(im.ctx, im.conduit, servicePHID, serviceName, interfacePHID, device)
+}
+
+// properties sets the variables as properties of the service or device. The secrets are set as
+// Passphrase references when the secrets file has their monogram and skipped otherwise.
+func (im *importer) properties(target PropertyTarget, vars map[string]interface{}, object string, source string) error {
+	at := InventoryError{Device: source}
+	if object == "service" {
+		at = InventoryError{Group: source}
+	}
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	changed := make(map[string]string)
+	for _, key := range keys {
+		at.Property = key
+		name := target.Name + " " + target.Key(key)
+		value, err := importValue(vars[key])
+		if err != nil {
+			at.Reason = err.Error()
+			return NewError(DataError, at, "can not import the variable")
+		}
+		detail := ""
+		if secret, found := im.secrets[secretKey(object, source, key)]; found {
+			if secret.Monogram == "" && im.options.SecretsFile == "" {
+				im.add(ImportSkip, "property", name, "secret, the import needs --secrets-file")
+				continue
+			}
+			if secret.Monogram == "" {
+				im.add(ImportSkip, "property", name, "secret, add the monogram of its Passphrase credential to "+im.options.SecretsFile)
+				continue
+			}
+			if value, err = PassphraseReference(im.ctx, im.conduit, im.config, secret.Monogram); err != nil {
+				return err
+			}
+			detail = "reference to the Passphrase credential " + value
+		} else if value, err = EncodePropertyValue(key, value, im.config); err != nil {
+			at.Reason = err.Error()
+			return NewError(DataError, at, "can not import the variable")
+		}
+		if old, found := target.Value(key); found && old == value {
+			im.add(ImportKeep, "property", name, "")
+			continue
+		}
+		im.add(ImportSet, "property", name, detail)
+		changed[key] = value
+	}
+	if im.options.DryRun || len(changed) == 0 {
+		return nil
+	}
+	return target.Set(im.ctx, im.conduit, changed)
+}
+
+// importValue returns the text of a variable, lists and maps are encoded as json.
+func importValue(value interface{}) (string, error) {
+	switch typed := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return typed, nil
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(typed)
+		return string(encoded), err
+	}
+	return fmt.Sprint(value), nil
+}
+
+// renamed describes the change of the name in Almanac.
+func renamed(name string, almanac string) string {
+	if name == almanac {
+		return ""
+	}
+	return name + " is named " + almanac + " in Almanac"
+}
+
+// PrintImport prints a line for every step.
+func PrintImport(w io.Writer, steps []ImportStep, dryRun bool) {
+	if dryRun {
+		fmt.Fprintln(w, "dry run, nothing is changed in Almanac")
+	}
+	for _, step := range steps {
+		line := fmt.Sprintf("%-6s %-9s %s", step.Action, step.Object, step.Name)
+		if step.Detail != "" {
+			line += " (" + step.Detail + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// ImportChanged returns true if the steps change Almanac.
+func ImportChanged(steps []ImportStep) bool {
+	for _, step := range steps {
+		if step.Action == ImportCreate || step.Action == ImportSet {
+			return true
+		}
+	}
+	return false
+}
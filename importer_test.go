@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	responses := map[string]string{
+		"almanac.network.search": `{"data":[{"phid":"PHID-ANET-1","fields":{"name":"dmz"}}]}`,
+		"almanac.service.search": `{"data":[{"phid":"PHID-ASRV-1","fields":{"name":"web-servers"},"attachments":{
+			"properties":{"properties":[{"key":"http-port","value":"80"}]},
+			"bindings":{"bindings":[{"interface":{"device":{"name":"web01"}}}]}}}],"cursor":{"after":null}}`,
+		"almanac.device.search":    `{"data":[{"phid":"PHID-ADEV-1","fields":{"name":"web01"},"attachments":{"properties":{"properties":[]}}}],"cursor":{"after":null}}`,
+		"almanac.interface.search": `{"data":[{"phid":"PHID-AINT-1","fields":{"networkPHID":"PHID-ANET-1","address":"10.0.0.1","port":22}}],"cursor":{"after":null}}`,
+		"passphrase.query":         `{"data":[]}`,
+	}
+	var edits []map[string]interface{}
+	server := editServer(t, responses, &edits)
+	defer server.Close()
+	p := NewConduit(server.URL, "api-token")
+	Config := defaultConfig()
+	Config.Wrapper.Passphrase = testPassphraseWrapper
+	Config.Wrapper.Json = testJsonWrapper
+
+	inventory := &StaticInventory{Groups: make(map[string]*StaticGroup), Hosts: make(map[string]map[string]interface{})}
+	inventory.addHost("web_servers", "web01", map[string]interface{}{"ansible_host": "10.0.0.1"})
+	inventory.addHost("db", "DB_01", map[string]interface{}{"ansible_host": "10.0.0.9", "ansible_port": 2222, "db_password": "s3cret", "root_key": "(K42)"})
+	inventory.group("web_servers").Vars["http_port"] = 80
+	inventory.group("db").Vars["replicas"] = []interface{}{"db02"}
+	inventory.group("all").Vars["ntp_server"] = "ntp.example.com"
+
+	_, err := Import(context.Background(), p, Config, inventory, ImportOptions{Network: "dmz"})
+	if ExitCode(err) != exitCodes[ConfigError] || !strings.Contains(err.Error(), "DB_01 db_password") {
+		t.Errorf("expected a configuration error listing the secrets, got %v", err)
+	}
+	if len(edits) != 0 {
+		t.Errorf("the secrets should be found before any change, got %v", edits)
+	}
+
+	steps, err := Import(context.Background(), p, Config, inventory, ImportOptions{Network: "dmz", DryRun: true})
+	if err != nil || len(steps) < 9 || steps[8] != (ImportStep{ImportSkip, "property", "db-01 db-password", "secret, the import needs --secrets-file"}) {
+		t.Errorf("expected the secret as skip step of the dry run, got %v %v", steps, err)
+	}
+
+	secretsFile := filepath.Join(t.TempDir(), "secrets.yml")
+	steps, err = Import(context.Background(), p, Config, inventory, ImportOptions{Network: "dmz", DryRun: true, SecretsFile: secretsFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ImportStep{
+		{ImportSkip, "service", "all", "the variables of all have no service, use an overlay"},
+		{ImportCreate, "service", "db", ""},
+		{ImportSet, "property", "db replicas", ""},
+		{ImportKeep, "service", "web-servers", ""},
+		{ImportKeep, "property", "web-servers http-port", ""},
+		{ImportCreate, "device", "db-01", "DB_01 is named db-01 in Almanac"},
+		{ImportCreate, "interface", "10.0.0.9:2222 in dmz", ""},
+		{ImportCreate, "binding", "db db-01", ""},
+		{ImportSkip, "property", "db-01 db-password", "secret, add the monogram of its Passphrase credential to " + secretsFile},
+		{ImportSet, "property", "db-01 root-key", ""},
+		{ImportKeep, "device", "web01", ""},
+		{ImportKeep, "interface", "10.0.0.1:22 in dmz", ""},
+		{ImportKeep, "binding", "web-servers web01", ""},
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("unexpected plan:\n%v", steps)
+	}
+	if len(edits) != 0 {
+		t.Errorf("the dry run should change nothing, got %v", edits)
+	}
+	if _, err = os.Stat(secretsFile); !os.IsNotExist(err) {
+		t.Errorf("the dry run should write no secrets file, got %v", err)
+	}
+
+	steps, err = Import(context.Background(), p, Config, inventory, ImportOptions{Network: "dmz", SecretsFile: secretsFile})
+	if err != nil || !reflect.DeepEqual(steps, expected) {
+		t.Errorf("the import should do the plan, got %v %v", steps, err)
+	}
+	secrets, err := ReadSecretsFile(secretsFile)
+	expectedSecrets := []ImportSecret{{Object: "device", Name: "db-01", Source: "DB_01", Key: "db_password"}}
+	if err != nil || !reflect.DeepEqual(secrets, expectedSecrets) {
+		t.Errorf("unexpected secrets file %v %v", secrets, err)
+	}
+	if content, _ := ioutil.ReadFile(secretsFile); strings.Contains(string(content), "s3cret") {
+		t.Errorf("the secrets file should have no values:\n%s", content)
+	}
+
+	var methods []string
+	for _, edit := range edits {
+		methods = append(methods, edit["method"].(string))
+	}
+	expectedMethods := []string{"almanac.service.edit", "almanac.service.edit", "almanac.device.edit",
+		"almanac.interface.edit", "almanac.binding.edit", "almanac.device.edit"}
+	if !reflect.DeepEqual(methods, expectedMethods) {
+		t.Errorf("unexpected edits %v", methods)
+	}
+	if _, err = Import(context.Background(), p, Config, inventory, ImportOptions{}); ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error without network, got %v", err)
+	}
+}
+
+func TestImportSecretsFile(t *testing.T) {
+	responses := map[string]string{
+		"almanac.network.search":   `{"data":[{"phid":"PHID-ANET-1","fields":{"name":"dmz"}}]}`,
+		"almanac.service.search":   `{"data":[],"cursor":{"after":null}}`,
+		"almanac.device.search":    `{"data":[{"phid":"PHID-ADEV-1","fields":{"name":"db01"},"attachments":{"properties":{"properties":[]}}}],"cursor":{"after":null}}`,
+		"almanac.interface.search": `{"data":[{"phid":"PHID-AINT-1","fields":{"networkPHID":"PHID-ANET-1","address":"db01","port":22}}],"cursor":{"after":null}}`,
+		"passphrase.query":         `{"data":{"PHID-CDTL-1":{"monogram":"K42","material":{"password":"s3cret"}}}}`,
+	}
+	var edits []map[string]interface{}
+	server := editServer(t, responses, &edits)
+	defer server.Close()
+	p := NewConduit(server.URL, "api-token")
+	Config := defaultConfig()
+	Config.Wrapper.Passphrase = testPassphraseWrapper
+	Config.Wrapper.Json = testJsonWrapper
+
+	inventory := &StaticInventory{Groups: make(map[string]*StaticGroup), Hosts: make(map[string]map[string]interface{})}
+	inventory.addHost("ungrouped", "db01", map[string]interface{}{"db_password": "s3cret", "api_token": "$ANSIBLE_VAULT;1.1;AES256"})
+	secretsFile := filepath.Join(t.TempDir(), "secrets.yml")
+	err := WriteSecretsFile(secretsFile, []ImportSecret{{Object: "device", Name: "db01", Source: "db01", Key: "db_password", Monogram: "K42"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := Import(context.Background(), p, Config, inventory, ImportOptions{Network: "dmz", SecretsFile: secretsFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ImportStep{
+		{ImportKeep, "device", "db01", ""},
+		{ImportKeep, "interface", "db01:22 in dmz", ""},
+		{ImportSkip, "binding", "db01", "the host is in no group, so it is not in the inventory"},
+		{ImportSkip, "property", "db01 api-token", "secret, add the monogram of its Passphrase credential to " + secretsFile},
+		{ImportSet, "property", "db01 db-password", "reference to the Passphrase credential (K42)"},
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("unexpected plan:\n%v", steps)
+	}
+	if len(edits) != 1 || !strings.Contains(fmt.Sprint(edits[0]), "(K42)") || strings.Contains(fmt.Sprint(edits[0]), "s3cret") {
+		t.Errorf("expected the reference as property, got %v", edits)
+	}
+	secrets, err := ReadSecretsFile(secretsFile)
+	expectedSecrets := []ImportSecret{
+		{Object: "device", Name: "db01", Source: "db01", Key: "api_token"},
+		{Object: "device", Name: "db01", Source: "db01", Key: "db_password", Monogram: "K42"},
+	}
+	if err != nil || !reflect.DeepEqual(secrets, expectedSecrets) {
+		t.Errorf("the monograms should be kept, got %v %v", secrets, err)
+	}
+}
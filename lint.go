@@ -0,0 +1,162 @@
+package main
+
+// a2a lint checks the Almanac data for the mistakes that the inventory silently skips or only
+// reports in partial mode: broken json and passphrase references, group names and keys that are
+// changed by the conversion to Ansible names, invalid special properties and unbound devices.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+)
+
+// The severities of the lint problems, only the errors fail the lint without --strict.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintProblem is a problem found by the linter.
+type LintProblem struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	InventoryError
+}
+
+// linter collects the problems of one Phabricator instance.
+type linter struct {
+	ctx         context.Context
+	conduit     *Conduit
+	passphrase  *regexp.Regexp
+	jsonWrapper string
+	// monograms are the results of the passphrase lookups, every monogram is only read once.
+	monograms map[string]error
+	problems  []LintProblem
+}
+
+// Lint checks all the services, bindings and devices of Almanac.
+func Lint(ctx context.Context, p *Conduit, Config Configuration) (problems []LintProblem, err error) {
+	passphrase, err := regexp.Compile(Config.Wrapper.Passphrase)
+	if err != nil {
+		return problems, NewError(ConfigError, err, "invalid Wrapper.Passphrase")
+	}
+	if _, err = regexp.Compile(Config.Wrapper.Json); err != nil {
+		return problems, NewError(ConfigError, err, "invalid Wrapper.Json")
+	}
+	services, err := p.GetServices(ctx)
+	if err != nil {
+		return problems, err
+	}
+	devices, err := p.GetAllDevices(ctx)
+	if err != nil {
+		return problems, err
+	}
+	l := &linter{ctx: ctx, conduit: p, passphrase: passphrase, jsonWrapper: Config.Wrapper.Json, monograms: make(map[string]error)}
+
+	sort.Slice(services, func(i, j int) bool { return services[i].Fields.Name < services[j].Fields.Name })
+	bound := make(map[string]bool)
+	for _, service := range services {
+		name := service.Fields.Name
+		if sanitized := sanitizeGroupName(name); sanitized != name {
+			l.add("group-name", LintWarning, InventoryError{Group: name}, "the group is named %s in the inventory", sanitized)
+		}
+		l.properties(service.Attachments.Properties.Properties, InventoryError{Group: name})
+		for _, binding := range service.Attachments.Bindings.Bindings {
+			bound[binding.Interface.Device.Name] = true
+			l.properties(binding.Properties, InventoryError{Group: name, Device: binding.Interface.Device.Name})
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Fields.Name < devices[j].Fields.Name })
+	for _, device := range devices {
+		name := device.Fields.Name
+		l.properties(device.Attachments.Properties.Properties, InventoryError{Device: name})
+		if !bound[name] {
+			l.add("ungrouped", LintWarning, InventoryError{Device: name}, "the device is bound to no service and not in the inventory")
+		}
+	}
+	if ctx.Err() != nil {
+		return l.problems, NewError(NetworkError, ctx.Err(), "the lint was stopped")
+	}
+	return l.problems, nil
+}
+
+// add adds a problem at the given place.
+func (l *linter) add(check string, severity string, at InventoryError, format string, args ...interface{}) {
+	at.Reason = fmt.Sprintf(format, args...)
+	l.problems = append(l.problems, LintProblem{Check: check, Severity: severity, InventoryError: at})
+}
+
+// properties checks the keys and values of the properties of a service, binding or device.
+func (l *linter) properties(properties []Property, at InventoryError) {
+	sorted := append([]Property{}, properties...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	keys := make(map[string]string)
+	for _, property := range sorted {
+		at.Property = property.Key
+		key := ReplaceToUnderscore(property.Key)
+		if other, found := keys[key]; found {
+			l.add("key-collision", LintError, at, "%s and %s are both %s in the inventory", other, property.Key, key)
+		}
+		keys[key] = property.Key
+		l.value(key, property.Value, at)
+	}
+}
+
+// value checks a property value: the passphrase references, the json and the special properties.
+func (l *linter) value(key string, value string, at InventoryError) {
+	if monogram, isPassphrase := passphraseName(l.passphrase, value); isPassphrase {
+		err, found := l.monograms[monogram]
+		if !found {
+			_, _, err = HandlePassphrase(l.ctx, l.conduit, l.passphrase.String(), value)
+			l.monograms[monogram] = err
+		}
+		if err != nil {
+			l.add("passphrase", LintError, at, "%v", err)
+		}
+		return
+	}
+	decoded, isJson, err := HandleJson(l.jsonWrapper, value)
+	if err != nil {
+		l.add("json", LintError, at, "invalid json: %v", err)
+		return
+	}
+	if _, special := propertySchemas[key]; !special {
+		return
+	}
+	if !isJson {
+		l.add("schema", LintError, at, "the value is not json")
+		return
+	}
+	for _, problem := range ValidateProperty(key, decoded) {
+		l.add("schema", LintError, at, "%v", problem)
+	}
+	for _, problem := range UnknownKeys(key, decoded) {
+		l.add("schema", LintWarning, at, "%v", problem)
+	}
+}
+
+// PrintLint prints a line for every problem.
+func PrintLint(w io.Writer, problems []LintProblem) {
+	for _, problem := range problems {
+		fmt.Fprintf(w, "%-7s %-13s %s\n", problem.Severity, problem.Check, problem.Error())
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "no problems found")
+	}
+}
+
+// CheckLint returns an error when there are errors, with strict the warnings are errors too.
+func CheckLint(problems []LintProblem, strict bool) error {
+	count := 0
+	for _, problem := range problems {
+		if problem.Severity == LintError || strict {
+			count++
+		}
+	}
+	if count > 0 {
+		return NewError(DataError, nil, "lint found %d problems", count)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	responses := map[string]string{
+		"almanac.service.search": `{"data":[{"fields":{"name":"mysql-servers"},"attachments":{
+			"properties":{"properties":[
+				{"key":"prometheus-config","value":"[{\"name\":\"mysql\"}]"},
+				{"key":"db-user","value":"root"},
+				{"key":"db_user","value":"admin"}]},
+			"bindings":{"bindings":[{"properties":[{"key":"replica-id","value":"{\"id\":}"}],"interface":{"device":{"name":"db01"}}}]}}}],
+			"cursor":{"after":null}}`,
+		"almanac.device.search": `{"data":[
+			{"fields":{"name":"db01"},"attachments":{"properties":{"properties":[{"key":"root-password","value":"(K42)"},{"key":"admin-password","value":"(K42)"}]}}},
+			{"fields":{"name":"old01"},"attachments":{"properties":{"properties":[]}}}],
+			"cursor":{"after":null}}`,
+		"passphrase.query": `{"data":[]}`,
+	}
+	var passphraseCalls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		if method == "passphrase.query" {
+			passphraseCalls++
+		}
+		w.Write([]byte(`{"result":` + responses[method] + `,"error_code":null,"error_info":null}`))
+	}))
+	defer server.Close()
+
+	Config := defaultConfig()
+	Config.Wrapper.Passphrase = testPassphraseWrapper
+	Config.Wrapper.Json = testJsonWrapper
+	problems, err := Lint(context.Background(), NewConduit(server.URL, "api-token"), Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, problem := range problems {
+		found = append(found, problem.Check+" "+problem.InventoryError.Error())
+	}
+	expected := []string{
+		"group-name group mysql-servers: the group is named mysql_servers in the inventory",
+		"key-collision group mysql-servers, property db_user: db-user and db_user are both db_user in the inventory",
+		"schema group mysql-servers, property prometheus-config: [0].port is required",
+		"json device db01, group mysql-servers, property replica-id: invalid json: invalid character '}' looking for beginning of value",
+		"passphrase device db01, property admin-password: passphrase K42 is not found or has no Conduit access",
+		"passphrase device db01, property root-password: passphrase K42 is not found or has no Conduit access",
+		"ungrouped device old01: the device is bound to no service and not in the inventory",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("unexpected problems:\n%s", strings.Join(found, "\n"))
+	}
+	if passphraseCalls != 1 {
+		t.Errorf("expected the passphrase to be read once, got %d calls", passphraseCalls)
+	}
+	if err := CheckLint(problems[:1], false); err != nil {
+		t.Errorf("the warnings should not fail without strict, got %v", err)
+	}
+	if ExitCode(CheckLint(problems[:1], true)) != exitCodes[DataError] {
+		t.Error("the warnings should fail with strict")
+	}
+}
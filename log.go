@@ -0,0 +1,41 @@
+package main
+
+// The log is written to stderr, as stdout contains the inventory. Per default only
+// warnings are written. --verbose adds the cache, the fetched objects and the
+// passphrase resolutions, --trace adds every Conduit call with its duration.
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// The log formats of Log.Format.
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// logger is the logger of the application, it is replaced by SetupLogging.
+var logger = NewLogger(os.Stderr, slog.LevelWarn, LogFormatText)
+
+// NewLogger creates a leveled, structured logger writing to w in the given format.
+func NewLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	if format == LogFormatJson {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+// SetupLogging sets the logger of the application for the given mode.
+func SetupLogging(verbose bool, trace bool, format string) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if trace {
+		level = slog.LevelDebug
+	}
+	logger = NewLogger(os.Stderr, level, format)
+}
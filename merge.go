@@ -0,0 +1,232 @@
+package main
+
+// Several Almanac instances can be merged to one inventory. Every instance is a profile
+// of the configuration, its inventory is listed with its own Conduit client and wrappers
+// and merged afterwards, so the prometheus and alertmanager modes see the merged view too.
+
+import (
+	"context"
+	"github.com/urfave/cli"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The values of Merge.Conflict.
+const (
+	ConflictPrefer = "prefer"
+	ConflictError  = "error"
+	ConflictRename = "rename"
+)
+
+// sourceVar is the host variable with the name of the profile the host comes from.
+const sourceVar = "a2a_source"
+
+// InventorySource is one of the merged Almanac instances.
+type InventorySource struct {
+	Name        string
+	GroupPrefix string
+	Config      Configuration
+	Conduit     *Conduit
+}
+
+// MergeOptions defines which profiles are merged and how the conflicts are solved.
+type MergeOptions struct {
+	Profiles []string
+	Conflict string
+	Prefer   string
+}
+
+// GetMergeOptions returns the merged profiles from --merge or the Merge section.
+func GetMergeOptions(c *cli.Context, Config Configuration) (options MergeOptions, err error) {
+	options.Profiles = Config.Merge.Profile
+	if profiles := lookupString(c, "merge"); profiles != "" {
+		options.Profiles = splitGroups(profiles)
+	}
+	options.Conflict = Config.Merge.Conflict
+	if options.Conflict == "" {
+		options.Conflict = ConflictPrefer
+	}
+	if options.Conflict != ConflictPrefer && options.Conflict != ConflictError && options.Conflict != ConflictRename {
+		return options, NewError(ConfigError, nil, "invalid Merge.Conflict %q, use prefer, error or rename", options.Conflict)
+	}
+	if len(options.Profiles) == 0 {
+		return options, nil
+	}
+	options.Prefer = Config.Merge.Prefer
+	if options.Prefer == "" {
+		options.Prefer = options.Profiles[0]
+	}
+	for _, profile := range options.Profiles {
+		if profile == options.Prefer {
+			return options, nil
+		}
+	}
+	return options, NewError(ConfigError, nil, "Merge.Prefer %s is not one of the merged profiles %s", options.Prefer, strings.Join(options.Profiles, ", "))
+}
+
+// LoadSources reads the configuration of every merged profile and creates its Conduit client.
+// The environment overrides are not used, as they would replace the instance of every profile.
+func LoadSources(options MergeOptions) (sources []InventorySource, err error) {
+	if os.Getenv(envApiURL) != "" || os.Getenv(envApiToken) != "" {
+		logger.Warn("the environment overrides are not used for merged profiles", "profiles", strings.Join(options.Profiles, ","))
+	}
+	for _, name := range options.Profiles {
+		source := InventorySource{Name: name}
+		source.Config, _, err = loadConfig(name, false)
+		if err != nil {
+			return sources, err
+		}
+		source.GroupPrefix = source.Config.Profile[name].GroupPrefix
+		source.Conduit, err = CreateConduit(source.Config)
+		if err != nil {
+			return sources, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// ListSource lists the inventory of a single source. When augment is set the passphrases
+// and json values are resolved with the wrappers of the source.
+func ListSource(ctx context.Context, source InventorySource, partial bool, augment bool) (output Output, err error) {
+	output, err = List(ctx, source.Conduit, source.Config.Ansible.Playbook, "", partial)
+	if err != nil {
+		return output, err
+	}
+	output.AddBindingVars(source.GroupPrefix, source.Config.Bindings.Flatten)
+	err = output.AddAutoGroups(ctx, source.Conduit, source.Config)
+	if err != nil {
+		return output, err
+	}
+	if augment {
+		problems := output.Augment(ctx, source.Conduit, source.Config.Wrapper.Passphrase, source.Config.Wrapper.Json)
+		if ctx.Err() != nil {
+			return output, NewError(NetworkError, ctx.Err(), "profile %s", source.Name)
+		}
+		output.Meta.Errors = append(output.Meta.Errors, problems...)
+	}
+	return output, nil
+}
+
+// ListSources lists the inventory of every source and merges them.
+func ListSources(ctx context.Context, sources []InventorySource, options MergeOptions, partial bool, augment bool) (output Output, err error) {
+	outputs := make([]Output, len(sources))
+	for i, source := range sources {
+		outputs[i], err = ListSource(ctx, source, partial, augment)
+		if err != nil {
+			if ctx.Err() != nil {
+				return output, NewError(NetworkError, ctx.Err(), "profile %s", source.Name)
+			}
+			return output, NewError(KindOf(err), err, "profile %s", source.Name)
+		}
+		logger.Info("profile listed", "profile", source.Name, "hosts", len(outputs[i].Meta.HostVars))
+	}
+	return MergeOutputs(sources, outputs, options)
+}
+
+// MergeOutputs merges the inventories of the sources. The groups get the prefix of their source
+// and every host gets the variable a2a_source. The preferred source is merged first, so it keeps
+// the host names and its variables win for the groups found in several sources.
+func MergeOutputs(sources []InventorySource, outputs []Output, options MergeOptions) (merged Output, err error) {
+	merged.Group = make(map[string]Group)
+	merged.Meta.HostVars = make(map[string]map[string]interface{})
+	owners := make(map[string]string)
+
+	order := make([]int, 0, len(sources))
+	for i, source := range sources {
+		if source.Name == options.Prefer {
+			order = append([]int{i}, order...)
+		} else {
+			order = append(order, i)
+		}
+	}
+	for _, i := range order {
+		source, output := sources[i], outputs[i]
+		names := make(map[string]string, len(output.Meta.HostVars))
+		hosts := make([]string, 0, len(output.Meta.HostVars))
+		for host := range output.Meta.HostVars {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			vars := output.Meta.HostVars[host]
+			name := host
+			if owner, found := owners[host]; found {
+				switch options.Conflict {
+				case ConflictError:
+					return merged, NewError(DataError, nil, "the host %s is in the profiles %s and %s", host, owner, source.Name)
+				case ConflictPrefer:
+					logger.Info("host of the preferred profile is used", "host", host, "profile", owner, "ignored", source.Name)
+					names[host] = host
+					continue
+				case ConflictRename:
+					name = source.Name + "-" + host
+					if _, ok := vars["ansible_host"]; !ok {
+						vars["ansible_host"] = host
+					}
+				}
+			}
+			if vars == nil {
+				vars = make(map[string]interface{})
+			}
+			vars[sourceVar] = source.Name
+			merged.Meta.HostVars[name] = vars
+			owners[name] = source.Name
+			names[host] = name
+		}
+
+		for groupName, group := range output.Group {
+			mergedName := source.GroupPrefix + groupName
+			mergedGroup, found := merged.Group[mergedName]
+			if !found {
+				mergedGroup = Group{Hosts: []string{}, Vars: make(map[string]interface{}), Addresses: make(map[string]string), Status: make(map[string]string)}
+			}
+			for _, host := range group.Hosts {
+				name := names[host]
+				if name == "" {
+					name = host
+				}
+				if !containsString(mergedGroup.Hosts, name) {
+					mergedGroup.Hosts = append(mergedGroup.Hosts, name)
+				}
+				if address, ok := group.Addresses[host]; ok {
+					if _, exists := mergedGroup.Addresses[name]; !exists {
+						mergedGroup.Addresses[name] = address
+					}
+				}
+				if status, ok := group.Status[host]; ok {
+					mergedGroup.Status[name] = status
+				}
+			}
+			for key, value := range group.Vars {
+				if _, exists := mergedGroup.Vars[key]; !exists {
+					mergedGroup.Vars[key] = value
+				}
+			}
+			merged.Group[mergedName] = mergedGroup
+		}
+
+		for _, problem := range output.Meta.Errors {
+			problem.Source = source.Name
+			if problem.Device != "" && names[problem.Device] != "" {
+				problem.Device = names[problem.Device]
+			}
+			if problem.Group != "" {
+				problem.Group = source.GroupPrefix + problem.Group
+			}
+			merged.Meta.Errors = append(merged.Meta.Errors, problem)
+		}
+	}
+	return merged, nil
+}
+
+// containsString returns true if the list contains the value.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testMergeOutputs returns the inventories of two instances which both contain web01.
+func testMergeOutputs() ([]InventorySource, []Output) {
+	sources := []InventorySource{{Name: "core"}, {Name: "institute", GroupPrefix: "inst_"}}
+	var core, institute Output
+	core.Group = map[string]Group{"web": {
+		Hosts:     []string{"web01"},
+		Vars:      map[string]interface{}{"prometheus_config": `[{"name": "node", "port": 9100}]`},
+		Addresses: map[string]string{"web01": "10.0.0.1"},
+	}}
+	core.Meta.HostVars = map[string]map[string]interface{}{"web01": {"role": "core"}}
+	institute.Group = map[string]Group{"web": {
+		Hosts:     []string{"web01", "web02"},
+		Vars:      map[string]interface{}{},
+		Addresses: map[string]string{"web01": "10.1.0.1", "web02": "10.1.0.2"},
+	}}
+	institute.Meta.HostVars = map[string]map[string]interface{}{"web01": {"role": "institute"}, "web02": {}}
+	institute.Meta.Errors = []InventoryError{{Device: "web01", Property: "password", Reason: "not found"}}
+	return sources, []Output{core, institute}
+}
+
+func TestMergeOutputsPrefer(t *testing.T) {
+	sources, outputs := testMergeOutputs()
+	merged, err := MergeOutputs(sources, outputs, MergeOptions{Conflict: ConflictPrefer, Prefer: "institute"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Meta.HostVars["web01"]["role"] != "institute" || merged.Meta.HostVars["web01"][sourceVar] != "institute" {
+		t.Errorf("expected the variables of the preferred profile, got %v", merged.Meta.HostVars["web01"])
+	}
+	if !reflect.DeepEqual(merged.GroupNames(), []string{"inst_web", "web"}) {
+		t.Errorf("unexpected groups %v", merged.GroupNames())
+	}
+	if !reflect.DeepEqual(merged.Group["web"].Hosts, []string{"web01"}) {
+		t.Errorf("unexpected hosts %v", merged.Group["web"].Hosts)
+	}
+	if merged.Meta.Errors[0].Source != "institute" {
+		t.Errorf("expected the profile in the problem, got %v", merged.Meta.Errors[0])
+	}
+}
+
+func TestMergeOutputsRename(t *testing.T) {
+	sources, outputs := testMergeOutputs()
+	merged, err := MergeOutputs(sources, outputs, MergeOptions{Conflict: ConflictRename, Prefer: "core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := merged.Meta.HostVars["institute-web01"]
+	if renamed["ansible_host"] != "web01" || renamed[sourceVar] != "institute" {
+		t.Errorf("unexpected variables of the renamed host %v", renamed)
+	}
+	if !reflect.DeepEqual(merged.Group["inst_web"].Hosts, []string{"institute-web01", "web02"}) {
+		t.Errorf("unexpected hosts %v", merged.Group["inst_web"].Hosts)
+	}
+	if merged.Group["inst_web"].Addresses["institute-web01"] != "10.1.0.1" {
+		t.Errorf("unexpected addresses %v", merged.Group["inst_web"].Addresses)
+	}
+	if merged.Meta.Errors[0].Device != "institute-web01" {
+		t.Errorf("expected the renamed host in the problem, got %v", merged.Meta.Errors[0])
+	}
+}
+
+func TestMergeOutputsError(t *testing.T) {
+	sources, outputs := testMergeOutputs()
+	_, err := MergeOutputs(sources, outputs, MergeOptions{Conflict: ConflictError, Prefer: "core"})
+	if ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data validation error, got %v", err)
+	}
+}
+
+func TestPrometheusDataOfMergedOutput(t *testing.T) {
+	sources, outputs := testMergeOutputs()
+	merged, err := MergeOutputs(sources, outputs, MergeOptions{Conflict: ConflictPrefer, Prefer: "core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, problems := GetPrometheusData(merged, testJsonWrapper, nil)
+	if len(problems) != 0 {
+		t.Fatal(problems)
+	}
+	if len(data) != 1 {
+		t.Fatalf("expected one target, got %v", data)
+	}
+	if !reflect.DeepEqual(data[0].Targets, []string{"10.0.0.1:9100"}) || data[0].Labels["group"] != "web" {
+		t.Errorf("unexpected target %v", data[0])
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+// An overlay is a local YAML file which adds hosts, groups and variables to the inventory
+// from Almanac or overrides its variables. The overlays are applied in the given order
+// after the inventory is read, so the last overlay wins.
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// ungroupedGroup contains the hosts of the overlays that are in no other group.
+const ungroupedGroup = "ungrouped"
+
+// OverlayFile is the content of an overlay:
+//
+//	hosts:
+//	  dev-vm:
+//	    ansible_host: 192.168.56.10
+//	groups:
+//	  web:
+//	    hosts: [dev-vm]
+//	    vars:
+//	      http_port: 8080
+type OverlayFile struct {
+	Hosts  map[string]map[string]interface{} `yaml:"hosts"`
+	Groups map[string]OverlayGroup           `yaml:"groups"`
+}
+
+// OverlayGroup adds hosts and variables to a group.
+type OverlayGroup struct {
+	Hosts []string               `yaml:"hosts"`
+	Vars  map[string]interface{} `yaml:"vars"`
+}
+
+// ReadOverlay reads the overlay from the given path.
+func ReadOverlay(path string) (overlay OverlayFile, err error) {
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return overlay, NewError(ConfigError, err, "can not read the overlay")
+	}
+	err = yaml.UnmarshalStrict(buffer, &overlay)
+	if err != nil {
+		return overlay, NewError(ConfigError, err, "can not parse the overlay %s", path)
+	}
+	return overlay, nil
+}
+
+// changedOverlay returns the first overlay that was changed after the given time, so a cache
+// written before is not used. A missing overlay counts as changed, as reading it fails.
+func changedOverlay(paths []string, since time.Time) string {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(since) {
+			return path
+		}
+	}
+	return ""
+}
+
+// ApplyOverlays reads the overlays of the configuration and applies them to the inventory in order.
+// Their passphrase and json values are resolved like the properties, the ones that fail are
+// removed and returned as problems.
+func (output *Output) ApplyOverlays(ctx context.Context, p *Conduit, Config Configuration) (problems []InventoryError, err error) {
+	for _, path := range Config.Overlay.File {
+		overlay, err := ReadOverlay(path)
+		if err != nil {
+			return problems, err
+		}
+		problems = append(problems, overlay.Resolve(ctx, p, Config)...)
+		output.ApplyOverlay(overlay)
+		logger.Info("overlay applied", "file", path, "hosts", len(overlay.Hosts), "groups", len(overlay.Groups))
+	}
+	return problems, nil
+}
+
+// Resolve resolves the passphrase and json values of the overlay with the wrappers of the
+// configuration. The values that can not be resolved are removed and returned as problems.
+func (overlay *OverlayFile) Resolve(ctx context.Context, p *Conduit, Config Configuration) (problems []InventoryError) {
+	for host, vars := range overlay.Hosts {
+		for key, value := range vars {
+			vars[key] = normalizeYaml(value)
+		}
+		for _, problem := range augmentVars(ctx, p, Config.Wrapper.Passphrase, Config.Wrapper.Json, vars) {
+			problem.Device = host
+			problems = append(problems, problem)
+		}
+	}
+	for name, group := range overlay.Groups {
+		for key, value := range group.Vars {
+			group.Vars[key] = normalizeYaml(value)
+		}
+		for _, problem := range augmentVars(ctx, p, Config.Wrapper.Passphrase, Config.Wrapper.Json, group.Vars) {
+			problem.Group = name
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// ApplyOverlay adds the hosts and groups of the overlay to the inventory. The hosts are added
+// to the groups and the variables of the overlay replace the ones from Almanac. The hosts that
+// are in no group are added to ungrouped.
+func (output *Output) ApplyOverlay(overlay OverlayFile) {
+	if output.Group == nil {
+		output.Group = make(map[string]Group)
+	}
+	if output.Meta.HostVars == nil {
+		output.Meta.HostVars = make(map[string]map[string]interface{})
+	}
+	for host, vars := range overlay.Hosts {
+		hostVars := output.Meta.HostVars[host]
+		if hostVars == nil {
+			hostVars = make(map[string]interface{})
+		}
+		for key, value := range vars {
+			hostVars[key] = normalizeYaml(value)
+		}
+		output.Meta.HostVars[host] = hostVars
+	}
+	for name, overlayGroup := range overlay.Groups {
+		group, found := output.Group[name]
+		if !found {
+			group = Group{Hosts: []string{}}
+		}
+		if group.Vars == nil {
+			group.Vars = make(map[string]interface{})
+		}
+		for _, host := range overlayGroup.Hosts {
+			if !containsString(group.Hosts, host) {
+				group.Hosts = append(group.Hosts, host)
+			}
+			if output.Meta.HostVars[host] == nil {
+				output.Meta.HostVars[host] = make(map[string]interface{})
+			}
+		}
+		for key, value := range overlayGroup.Vars {
+			group.Vars[key] = normalizeYaml(value)
+		}
+		output.Group[name] = group
+	}
+	for host := range overlay.Hosts {
+		if !output.hasGroup(host) {
+			output.AddHost(host, ungroupedGroup)
+		}
+	}
+}
+
+// hasGroup returns true if the host is in one of the groups.
+func (output *Output) hasGroup(host string) bool {
+	for _, group := range output.Group {
+		if containsString(group.Hosts, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeYaml converts the maps decoded by yaml to maps with string keys, so they can be encoded as json.
+func normalizeYaml(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			result[fmt.Sprint(key)] = normalizeYaml(item)
+		}
+		return result
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = normalizeYaml(item)
+		}
+		return typed
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = normalizeYaml(item)
+		}
+		return typed
+	}
+	return value
+}
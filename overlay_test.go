@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testOverlay = `
+hosts:
+  dev-vm:
+    ansible_host: 192.168.56.10
+  web01:
+    http_port: 8081
+    ports: "[80, 443]"
+    broken: "[80,]"
+  tmp-box:
+    users:
+      - name: test
+groups:
+  web:
+    hosts: [dev-vm]
+    vars:
+      http_port: 8080
+  test:
+    hosts: [web01]
+`
+
+func TestApplyOverlay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overlay.yml")
+	if err := ioutil.WriteFile(path, []byte(testOverlay), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var output Output
+	output.Group = map[string]Group{"web": {Hosts: []string{"web01"}, Vars: map[string]interface{}{"http_port": "80", "user": "www"}}}
+	output.Meta.HostVars = map[string]map[string]interface{}{"web01": {"http_port": "80", "role": "web"}}
+	Config := defaultConfig()
+	Config.Overlay.File = []string{path}
+	Config.Wrapper.Passphrase = testPassphraseWrapper
+	Config.Wrapper.Json = testJsonWrapper
+	problems, err := output.ApplyOverlays(context.Background(), nil, Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Device != "web01" || problems[0].Property != "broken" {
+		t.Errorf("expected the invalid json as problem, got %v", problems)
+	}
+	if !reflect.DeepEqual(output.Meta.HostVars["web01"]["ports"], []interface{}{80.0, 443.0}) {
+		t.Errorf("the json value of the overlay should be decoded, got %#v", output.Meta.HostVars["web01"]["ports"])
+	}
+	if !reflect.DeepEqual(output.Group["web"].Hosts, []string{"web01", "dev-vm"}) {
+		t.Errorf("unexpected hosts %v", output.Group["web"].Hosts)
+	}
+	if output.Group["web"].Vars["http_port"] != 8080 || output.Group["web"].Vars["user"] != "www" {
+		t.Errorf("unexpected group vars %v", output.Group["web"].Vars)
+	}
+	if output.Meta.HostVars["web01"]["http_port"] != 8081 || output.Meta.HostVars["web01"]["role"] != "web" {
+		t.Errorf("unexpected host vars %v", output.Meta.HostVars["web01"])
+	}
+	if !reflect.DeepEqual(output.Group["test"].Hosts, []string{"web01"}) {
+		t.Errorf("expected the new group, got %v", output.Group["test"])
+	}
+	if !reflect.DeepEqual(output.Group[ungroupedGroup].Hosts, []string{"tmp-box"}) {
+		t.Errorf("expected tmp-box in ungrouped, got %v", output.Group[ungroupedGroup])
+	}
+	if _, err := json.Marshal(output.Sanitize()); err != nil {
+		t.Errorf("the overlay can not be encoded: %v", err)
+	}
+}
+
+func TestReadOverlayRejectsUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overlay.yml")
+	if err := ioutil.WriteFile(path, []byte("host:\n  dev-vm: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ReadOverlay(path)
+	if ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error, got %v", err)
+	}
+}
+
+func TestChangedOverlay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overlay.yml")
+	if err := ioutil.WriteFile(path, []byte(testOverlay), 0600); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now().Add(-time.Minute)
+	os.Chtimes(path, written, written)
+	if changed := changedOverlay([]string{path}, time.Now()); changed != "" {
+		t.Errorf("the overlay is older than the cache, got %s", changed)
+	}
+	if changed := changedOverlay([]string{path}, written.Add(-time.Minute)); changed != path {
+		t.Errorf("the overlay is newer than the cache, got %q", changed)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.yml")
+	if changed := changedOverlay([]string{path, missing}, time.Now()); changed != missing {
+		t.Errorf("a missing overlay should count as changed, got %q", changed)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+// In partial mode the inventory is built even when single hosts or properties
+// can not be read. Every problem is listed in _meta.a2a_errors and the hosts
+// with problems are either left out or flagged with a2a_broken.
+
+import (
+	"strings"
+)
+
+// The values of Inventory.Broken.
+const (
+	BrokenOmit = "omit"
+	BrokenFlag = "flag"
+)
+
+// brokenVar is the host variable set for the flagged hosts.
+const brokenVar = "a2a_broken"
+
+// InventoryError is a problem with a single device, group or property.
+type InventoryError struct {
+	// Source is the profile of the problem in a merged inventory.
+	Source   string `json:"source,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Property string `json:"property,omitempty"`
+	Reason   string `json:"reason"`
+	err      error
+}
+
+func (e InventoryError) Error() string {
+	var parts []string
+	if e.Source != "" {
+		parts = append(parts, "profile "+e.Source)
+	}
+	if e.Device != "" {
+		parts = append(parts, "device "+e.Device)
+	}
+	if e.Group != "" {
+		parts = append(parts, "group "+e.Group)
+	}
+	if e.Property != "" {
+		parts = append(parts, "property "+e.Property)
+	}
+	if len(parts) == 0 {
+		return e.Reason
+	}
+	return strings.Join(parts, ", ") + ": " + e.Reason
+}
+
+func (e InventoryError) Unwrap() error {
+	return e.err
+}
+
+// HandleBroken removes the hosts with problems from the groups and the host variables.
+// When flag is set the hosts stay in the inventory and get the variable a2a_broken.
+func (output *Output) HandleBroken(flag bool) {
+	broken := make(map[string]bool)
+	for _, problem := range output.Meta.Errors {
+		if problem.Device != "" {
+			broken[problem.Device] = true
+		}
+	}
+	for host := range broken {
+		if flag {
+			if output.Meta.HostVars[host] == nil {
+				output.Meta.HostVars[host] = make(map[string]interface{})
+			}
+			output.Meta.HostVars[host][brokenVar] = true
+			continue
+		}
+		delete(output.Meta.HostVars, host)
+		for name, group := range output.Group {
+			hosts := []string{}
+			for _, groupHost := range group.Hosts {
+				if groupHost != host {
+					hosts = append(hosts, groupHost)
+				}
+			}
+			group.Hosts = hosts
+			output.Group[name] = group
+		}
+	}
+}
+
+// reportProblems logs the skipped hosts and groups of the monitoring outputs. Like in partial
+// mode the output is only written when there are not more problems than allowed.
+func reportProblems(problems []InventoryError, maxErrors int) error {
+	for _, problem := range problems {
+		logger.Warn("the invalid value is skipped", "error", problem.Error())
+	}
+	return CheckProblems(problems, true, maxErrors)
+}
+
+// CheckProblems decides if the run fails because of the given problems. Without partial mode
+// the first problem is returned, in partial mode an error is returned when there are more than
+// maxErrors problems.
+func CheckProblems(problems []InventoryError, partial bool, maxErrors int) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	if !partial {
+		return problems[0]
+	}
+	if maxErrors >= 0 && len(problems) > maxErrors {
+		return NewError(DataError, nil, "%d problems in the inventory, only %d are allowed, the first is %v",
+			len(problems), maxErrors, problems[0])
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+const testPassphraseWrapper = "^\\((?P<name>[a-z-A-Z0-9.-]+)\\)$"
+const testJsonWrapper = "^(\\[.*\\]|\\{.*\\})"
+
+func TestAugmentReportsInvalidJson(t *testing.T) {
+	var output Output
+	output.Group = map[string]Group{"web": {Hosts: []string{"web01", "web02"}, Vars: map[string]interface{}{}}}
+	output.Meta.HostVars = map[string]map[string]interface{}{
+		"web01": {"ports": "[80, 443]"},
+		"web02": {"ports": "[80, 443,]"},
+	}
+	problems := output.AugmentBlocking(context.Background(), nil, testPassphraseWrapper, testJsonWrapper)
+	if len(problems) != 1 {
+		t.Fatalf("expected one problem, got %v", problems)
+	}
+	if problems[0].Device != "web02" || problems[0].Property != "ports" {
+		t.Errorf("unexpected problem %v", problems[0])
+	}
+	if _, ok := output.Meta.HostVars["web02"]["ports"]; ok {
+		t.Error("the invalid property should be removed")
+	}
+	if ExitCode(CheckProblems(problems, false, 0)) != exitCodes[DataError] {
+		t.Error("expected a data validation error without partial mode")
+	}
+	if CheckProblems(problems, true, 1) != nil {
+		t.Error("expected no error below the threshold")
+	}
+	if ExitCode(CheckProblems(problems, true, 0)) != exitCodes[DataError] {
+		t.Error("expected a data validation error above the threshold")
+	}
+	if CheckProblems(problems, true, -1) != nil {
+		t.Error("expected no error without a threshold")
+	}
+}
+
+func TestHandleBroken(t *testing.T) {
+	var output Output
+	output.Group = map[string]Group{"web": {Hosts: []string{"web01", "web02"}}}
+	output.Meta.HostVars = map[string]map[string]interface{}{"web01": {}, "web02": {}}
+	output.Meta.Errors = []InventoryError{{Device: "web02", Property: "password", Reason: "not found"}}
+
+	output.HandleBroken(true)
+	if output.Meta.HostVars["web02"][brokenVar] != true || len(output.Group["web"].Hosts) != 2 {
+		t.Errorf("web02 should be flagged, got %v", output)
+	}
+	output.HandleBroken(false)
+	if _, ok := output.Meta.HostVars["web02"]; ok || len(output.Group["web"].Hosts) != 1 {
+		t.Errorf("web02 should be omitted, got %v", output)
+	}
+}
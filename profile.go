@@ -0,0 +1,101 @@
+package main
+
+// Profiles select one of several Phabricator instances from a single configuration.
+// A profile is chosen with --profile, A2A_PROFILE or the name of the binary, so a
+// symlink a2a-prod can be given to Ansible as inventory script of the prod profile.
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// envProfile selects the profile like --profile.
+const envProfile = "A2A_PROFILE"
+
+// profilePrefix is the prefix of the binary names that select a profile, ex. a2a-prod.
+const profilePrefix = "a2a-"
+
+// profileName is set with --profile or A2A_PROFILE.
+var profileName = ""
+
+// binaryProfile is the profile named by the binary. It is only used when the configuration has
+// this profile, so a renamed binary like a2a-linux-amd64 keeps the default configuration.
+var binaryProfile = ""
+
+// Profile is a [Profile "name"] section of the configuration. The values that are set
+// replace the ones of the Phabricator, Ansible, Wrapper and Cache sections.
+type Profile struct {
+	ApiURL          string
+	ApiToken        string
+	ApiTokenFile    string
+	ApiTokenCommand string
+	Playbook        string
+	// PassphraseWrapper and JsonWrapper replace Wrapper.Passphrase and Wrapper.Json.
+	PassphraseWrapper string
+	JsonWrapper       string
+	// CacheNamespace keeps the cache of the profile apart, it is the name of the profile per default.
+	CacheNamespace string
+	// GroupPrefix is added to the group names when the profile is merged with others.
+	GroupPrefix string
+}
+
+// profileFromArgs returns the profile selected by the name of the binary, ex. prod for a2a-prod.
+func profileFromArgs(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	name := filepath.Base(args[0])
+	if !strings.HasPrefix(name, profilePrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(name, profilePrefix)
+}
+
+// applyProfile replaces the values of the configuration with the ones of the given profile.
+func applyProfile(Config *Configuration, name string, sources ConfigSources) error {
+	profile, ok := Config.Profile[name]
+	if !ok || profile == nil {
+		var names []string
+		for profileName := range Config.Profile {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return NewError(ConfigError, nil, "the profile %s is not found, the configuration has no profiles", name)
+		}
+		return NewError(ConfigError, nil, "the profile %s is not found, use one of %s", name, strings.Join(names, ", "))
+	}
+	source := "profile " + name
+	set := func(key string, target *string, value string) {
+		if value != "" {
+			*target = value
+			sources.Values[key] = source
+		}
+	}
+	set("Phabricator.ApiURL", &Config.Phabricator.ApiURL, profile.ApiURL)
+	// The token of the profile replaces the token of the Phabricator section, whatever way it is given.
+	if profile.ApiToken != "" || profile.ApiTokenFile != "" || profile.ApiTokenCommand != "" {
+		Config.Phabricator.ApiToken = profile.ApiToken
+		Config.Phabricator.ApiTokenFile = profile.ApiTokenFile
+		Config.Phabricator.ApiTokenCommand = profile.ApiTokenCommand
+		for _, key := range []string{"Phabricator.ApiToken", "Phabricator.ApiTokenFile", "Phabricator.ApiTokenCommand"} {
+			sources.Values[key] = source
+		}
+	}
+	set("Ansible.Playbook", &Config.Ansible.Playbook, profile.Playbook)
+	set("Wrapper.Passphrase", &Config.Wrapper.Passphrase, profile.PassphraseWrapper)
+	set("Wrapper.Json", &Config.Wrapper.Json, profile.JsonWrapper)
+	Config.Cache.Namespace = name
+	sources.Values["Cache.Namespace"] = source
+	set("Cache.Namespace", &Config.Cache.Namespace, profile.CacheNamespace)
+	return nil
+}
+
+// cacheName returns the name of the cache file for the namespace of the configuration.
+func cacheName(Config Configuration) string {
+	if Config.Cache.Namespace == "" {
+		return cacheFile
+	}
+	return cacheFile + "." + Config.Cache.Namespace + "."
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+)
+
+const testProfileConfig = `[Phabricator]
+ApiURL = https://staging.example/api
+ApiToken = api-staging
+
+[Wrapper]
+Json = "^(\\[.*\\])"
+
+[Profile "prod"]
+ApiURL = https://prod.example/api
+ApiTokenCommand = echo api-prod
+Playbook = /etc/ansible/prod.yml
+`
+
+func TestProfileFromArgs(t *testing.T) {
+	tests := map[string]string{
+		"a2a":                     "",
+		"/usr/local/bin/a2a-prod": "prod",
+		"./a2a-staging":           "staging",
+		"inventory":               "",
+	}
+	for arg, expected := range tests {
+		if profile := profileFromArgs([]string{arg}); profile != expected {
+			t.Errorf("expected profile %q for %s, got %q", expected, arg, profile)
+		}
+	}
+}
+
+func TestLoadConfigProfile(t *testing.T) {
+	writeTestConfig(t, testProfileConfig)
+	t.Setenv(envApiURL, "")
+	t.Setenv(envApiToken, "")
+	defer func(name string) { profileName = name }(profileName)
+
+	profileName = ""
+	Config, _, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Config.Phabricator.ApiToken != "api-staging" || cacheName(Config) != cacheFile {
+		t.Errorf("unexpected configuration without profile %+v", Config.Phabricator)
+	}
+
+	// A binary name without a profile in the configuration keeps the default.
+	defer func(name string) { binaryProfile = name }(binaryProfile)
+	binaryProfile = "linux-amd64"
+	Config, _, err = LoadConfig()
+	if err != nil || Config.Phabricator.ApiToken != "api-staging" {
+		t.Errorf("expected the default configuration for a2a-linux-amd64, got %+v %v", Config.Phabricator, err)
+	}
+	binaryProfile = "prod"
+	Config, _, err = LoadConfig()
+	if err != nil || Config.Phabricator.ApiToken != "api-prod" {
+		t.Errorf("expected the profile of a2a-prod, got %+v %v", Config.Phabricator, err)
+	}
+	binaryProfile = ""
+
+	profileName = "prod"
+	Config, sources, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Config.Phabricator.ApiURL != "https://prod.example/api" || Config.Phabricator.ApiToken != "api-prod" {
+		t.Errorf("unexpected configuration of the profile %+v", Config.Phabricator)
+	}
+	if Config.Ansible.Playbook != "/etc/ansible/prod.yml" || Config.Wrapper.Json != "^(\\[.*\\])" {
+		t.Errorf("unexpected playbook or wrapper %s, %s", Config.Ansible.Playbook, Config.Wrapper.Json)
+	}
+	if cacheName(Config) != cacheFile+".prod." {
+		t.Errorf("unexpected cache name %s", cacheName(Config))
+	}
+	if sources.Values["Phabricator.ApiURL"] != "profile prod" {
+		t.Errorf("unexpected source %q", sources.Values["Phabricator.ApiURL"])
+	}
+
+	profileName = "test"
+	_, _, err = LoadConfig()
+	if ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error for a missing profile, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+// a2a property changes the properties of a single Almanac device or service. The keys are
+// written the way a2a reads them: an existing key with the same inventory name is reused,
+// new keys get dashes instead of underscores.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// PropertyTarget is the Almanac device or service whose properties are changed.
+type PropertyTarget struct {
+	// Kind is device or service, as in the names of the Conduit methods.
+	Kind       string
+	Name       string
+	PHID       string
+	Properties []Property
+}
+
+// GetPropertyTarget reads the device or the service with its properties, only one of them can be given.
+func GetPropertyTarget(ctx context.Context, p *Conduit, device string, service string) (target PropertyTarget, err error) {
+	if (device == "") == (service == "") {
+		return target, NewError(ConfigError, nil, "use either --device or --service")
+	}
+	if device != "" {
+		devices, err := p.GetDevice(ctx, device)
+		if err != nil {
+			return target, err
+		}
+		for _, found := range devices {
+			if found.Fields.Name == device {
+				return PropertyTarget{Kind: "device", Name: device, PHID: found.PHID, Properties: found.Attachments.Properties.Properties}, nil
+			}
+		}
+		return target, NewError(DataError, nil, "device %s is not found in Almanac", device)
+	}
+	services, err := p.GetService(ctx, service)
+	if err != nil {
+		return target, err
+	}
+	for _, found := range services {
+		if found.Fields.Name == service {
+			return PropertyTarget{Kind: "service", Name: service, PHID: found.PHID, Properties: found.Attachments.Properties.Properties}, nil
+		}
+	}
+	return target, NewError(DataError, nil, "service %s is not found in Almanac", service)
+}
+
+// Key returns the Almanac key for the given key. An existing property with the same inventory
+// name keeps its key, so db_user does not add a second db-user.
+func (t PropertyTarget) Key(key string) string {
+	for _, property := range t.Properties {
+		if ReplaceToUnderscore(property.Key) == ReplaceToUnderscore(key) {
+			return property.Key
+		}
+	}
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// Value returns the value of the property with the inventory name of key.
+func (t PropertyTarget) Value(key string) (string, bool) {
+	for _, property := range t.Properties {
+		if ReplaceToUnderscore(property.Key) == ReplaceToUnderscore(key) {
+			return property.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set writes the properties in one transaction, the keys are converted with Key.
+func (t PropertyTarget) Set(ctx context.Context, p *Conduit, values map[string]string) error {
+	set := make(map[string]string, len(values))
+	for key, value := range values {
+		set[t.Key(key)] = value
+	}
+	return p.EditProperties(ctx, t.Kind, t.PHID, t.Name, set, nil)
+}
+
+// Unset removes the property, it is no error when the property does not exist.
+func (t PropertyTarget) Unset(ctx context.Context, p *Conduit, key string) (bool, error) {
+	if _, found := t.Value(key); !found {
+		return false, nil
+	}
+	return true, p.EditProperties(ctx, t.Kind, t.PHID, t.Name, nil, []string{t.Key(key)})
+}
+
+// EncodePropertyValue returns the value as it is stored in Almanac. Lists and objects are
+// written as compact json, so they match Wrapper.Json, and the special properties are
+// checked against their schema.
+func EncodePropertyValue(key string, value string, Config Configuration) (string, error) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "{") {
+		if _, special := propertySchemas[ReplaceToUnderscore(key)]; special {
+			return value, NewError(DataError, nil, "%s must be json", key)
+		}
+		return value, nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(trimmed)); err != nil {
+		return value, NewError(DataError, err, "invalid json for %s", key)
+	}
+	var decoded interface{}
+	json.Unmarshal(compact.Bytes(), &decoded)
+	if err := validationError(ReplaceToUnderscore(key), ValidateProperty(key, decoded)); err != nil {
+		return value, NewError(DataError, err, "invalid %s", key)
+	}
+	jsonRegex, err := regexp.Compile(Config.Wrapper.Json)
+	if err != nil {
+		return value, NewError(ConfigError, err, "invalid Wrapper.Json")
+	}
+	if !jsonRegex.MatchString(compact.String()) {
+		logger.Warn("the value does not match Wrapper.Json and is read as text", "key", key)
+	}
+	return compact.String(), nil
+}
+
+// PassphraseReference returns the reference to the credential with the given monogram,
+// ex. (K42), after checking that a2a can read it.
+func PassphraseReference(ctx context.Context, p *Conduit, Config Configuration, monogram string) (string, error) {
+	reference := "(" + strings.Trim(monogram, "()") + ")"
+	if _, err := regexp.Compile(Config.Wrapper.Passphrase); err != nil {
+		return "", NewError(ConfigError, err, "invalid Wrapper.Passphrase")
+	}
+	_, isPassphrase, err := HandlePassphrase(ctx, p, Config.Wrapper.Passphrase, reference)
+	if err != nil {
+		return "", err
+	}
+	if !isPassphrase {
+		return "", NewError(ConfigError, nil, "the reference %s does not match Wrapper.Passphrase", reference)
+	}
+	return reference, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// editServer answers the searches with the given responses and records the params of the edit calls.
+func editServer(t *testing.T, responses map[string]string, edits *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		if strings.HasSuffix(method, ".edit") {
+			var params map[string]interface{}
+			if err := json.Unmarshal([]byte(r.FormValue("params")), &params); err != nil {
+				t.Error(err)
+			}
+			delete(params, "__conduit__")
+			params["method"] = method
+			*edits = append(*edits, params)
+			w.Write([]byte(`{"result":{"object":{"id":1},"transactions":[]},"error_code":null,"error_info":null}`))
+			return
+		}
+		w.Write([]byte(`{"result":` + responses[method] + `,"error_code":null,"error_info":null}`))
+	}))
+}
+
+func TestPropertyTarget(t *testing.T) {
+	responses := map[string]string{
+		"almanac.device.search":  `{"data":[{"phid":"PHID-ADEV-1","fields":{"name":"db01"},"attachments":{"properties":{"properties":[{"key":"db_user","value":"root"}]}}}]}`,
+		"almanac.service.search": `{"data":[]}`,
+	}
+	var edits []map[string]interface{}
+	server := editServer(t, responses, &edits)
+	defer server.Close()
+	p := NewConduit(server.URL, "api-token")
+	ctx := context.Background()
+
+	target, err := GetPropertyTarget(ctx, p, "db01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Key("db-user") != "db_user" || target.Key("db_port") != "db-port" {
+		t.Errorf("unexpected keys %s %s", target.Key("db-user"), target.Key("db_port"))
+	}
+	if err = target.Set(ctx, p, map[string]string{"db-user": "admin", "db_port": "3306"}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := target.Unset(ctx, p, "missing"); removed || err != nil {
+		t.Errorf("a missing property should not be removed, got %v %v", removed, err)
+	}
+	if removed, err := target.Unset(ctx, p, "db-user"); !removed || err != nil {
+		t.Errorf("expected the property to be removed, got %v %v", removed, err)
+	}
+	expected := []map[string]interface{}{
+		{"method": "almanac.device.edit", "objectIdentifier": "PHID-ADEV-1", "transactions": []interface{}{
+			map[string]interface{}{"type": "property.set", "value": map[string]interface{}{"db_user": "admin", "db-port": "3306"}},
+		}},
+		{"method": "almanac.device.edit", "objectIdentifier": "PHID-ADEV-1", "transactions": []interface{}{
+			map[string]interface{}{"type": "property.delete", "value": []interface{}{"db_user"}},
+		}},
+	}
+	if !reflect.DeepEqual(edits, expected) {
+		t.Errorf("unexpected edits %v", edits)
+	}
+
+	if _, err = GetPropertyTarget(ctx, p, "", "web"); ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data error for a missing service, got %v", err)
+	}
+	if _, err = GetPropertyTarget(ctx, p, "db01", "web"); ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error for both flags, got %v", err)
+	}
+}
+
+func TestEncodePropertyValue(t *testing.T) {
+	Config := defaultConfig()
+	Config.Wrapper.Json = testJsonWrapper
+	tests := []struct {
+		key, value, expected string
+		kind                 ErrorKind
+	}{
+		{"db-user", "root", "root", 0},
+		{"replicas", "[ \"db01\",\n  \"db02\" ]", `["db01","db02"]`, 0},
+		{"replicas", `{"a":`, "", DataError},
+		{"prometheus-config", `[{"name": "node", "port": 9100}]`, `[{"name":"node","port":9100}]`, 0},
+		{"prometheus_config", `[{"name":"node","port":"9100"}]`, "", DataError},
+		{"prometheus-config", "node", "", DataError},
+	}
+	for _, test := range tests {
+		value, err := EncodePropertyValue(test.key, test.value, Config)
+		if test.kind != 0 {
+			if ExitCode(err) != exitCodes[test.kind] {
+				t.Errorf("%s %q: expected an error, got %v", test.key, test.value, err)
+			}
+			continue
+		}
+		if err != nil || value != test.expected {
+			t.Errorf("%s %q: expected %s, got %s %v", test.key, test.value, test.expected, value, err)
+		}
+	}
+}
+
+func TestPassphraseReference(t *testing.T) {
+	responses := map[string]string{
+		"passphrase.query": `{"data":{"PHID-CDTL-1":{"monogram":"K42","material":{"password":"s3cret"}}}}`,
+	}
+	server := editServer(t, responses, nil)
+	defer server.Close()
+	p := NewConduit(server.URL, "api-token")
+	Config := defaultConfig()
+	Config.Wrapper.Passphrase = testPassphraseWrapper
+
+	reference, err := PassphraseReference(context.Background(), p, Config, "K42")
+	if err != nil || reference != "(K42)" {
+		t.Errorf("expected the reference (K42), got %s %v", reference, err)
+	}
+	if _, err = PassphraseReference(context.Background(), p, Config, "K7"); ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data error for an unknown credential, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+// a2a host register adds a host to Almanac the way the Almanac Data steps of the README do:
+// the device, its interface in a network and the bindings to the services. Every step first
+// looks for the existing object, so running it again changes nothing.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The actions of the register steps.
+const (
+	StepCreated   = "created"
+	StepExists    = "exists"
+	StepEnabled   = "enabled"
+	StepSet       = "set"
+	StepUnchanged = "unchanged"
+)
+
+// RegisterOptions are the host and the places it is added to.
+type RegisterOptions struct {
+	Name       string
+	Address    string
+	Port       int
+	Network    string
+	Services   []string
+	Properties map[string]string
+}
+
+// RegisterStep is one object of the registration and what was done with it.
+type RegisterStep struct {
+	Object string `json:"object"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// ParsePropertyFlags reads the key=value pairs of --property.
+func ParsePropertyFlags(pairs []string) (map[string]string, error) {
+	properties := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return properties, NewError(ConfigError, nil, "invalid property %q, use key=value", pair)
+		}
+		properties[key] = value
+	}
+	return properties, nil
+}
+
+// RegisterHost creates the device, the interface and the bindings that are missing and sets the properties.
+func RegisterHost(ctx context.Context, p *Conduit, Config Configuration, options RegisterOptions) (steps []RegisterStep, err error) {
+	steps = make([]RegisterStep, 0)
+	if options.Name == "" || options.Address == "" || options.Network == "" || len(options.Services) == 0 {
+		return steps, NewError(ConfigError, nil, "host register needs a name, --address, --network and --service")
+	}
+	if options.Port <= 0 || options.Port > 65535 {
+		return steps, NewError(ConfigError, nil, "invalid port %d", options.Port)
+	}
+	values := make(map[string]string, len(options.Properties))
+	for key, value := range options.Properties {
+		if values[key], err = EncodePropertyValue(key, value, Config); err != nil {
+			return steps, err
+		}
+	}
+	// The network and the services are only read, they are not created for a host.
+	networkPHID, err := findNetwork(ctx, p, options.Network)
+	if err != nil {
+		return steps, err
+	}
+	services := make([]Service, 0, len(options.Services))
+	for _, name := range options.Services {
+		service, err := findService(ctx, p, name)
+		if err != nil {
+			return steps, err
+		}
+		services = append(services, service)
+	}
+
+	target, action, err := ensureDevice(ctx, p, options.Name)
+	if err != nil {
+		return steps, err
+	}
+	steps = append(steps, RegisterStep{Object: "device", Name: options.Name, Action: action})
+
+	address := options.Address + ":" + strconv.Itoa(options.Port)
+	interfacePHID, action, err := ensureInterface(ctx, p, target, networkPHID, options.Address, options.Port)
+	if err != nil {
+		return steps, err
+	}
+	steps = append(steps, RegisterStep{Object: "interface", Name: address + " in " + options.Network, Action: action})
+
+	for _, service := range services {
+		action, err := ensureBinding(ctx, p, service, interfacePHID, options.Name)
+		if err != nil {
+			return steps, err
+		}
+		steps = append(steps, RegisterStep{Object: "binding", Name: service.Fields.Name, Action: action})
+	}
+
+	keys := make([]string, 0, len(values))
+	changed := make(map[string]string)
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		action := StepSet
+		if old, found := target.Value(key); found && old == values[key] {
+			action = StepUnchanged
+		} else {
+			changed[key] = values[key]
+		}
+		steps = append(steps, RegisterStep{Object: "property", Name: target.Key(key), Action: action})
+	}
+	return steps, target.Set(ctx, p, changed)
+}
+
+// findNetwork returns the PHID of the network with the given name.
+func findNetwork(ctx context.Context, p *Conduit, name string) (string, error) {
+	networks, err := p.GetNetworks(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	for _, network := range networks {
+		if network.Fields.Name == name {
+			return network.PHID, nil
+		}
+	}
+	return "", NewError(DataError, nil, "network %s is not found in Almanac", name)
+}
+
+// findService returns the service with the given name and its bindings.
+func findService(ctx context.Context, p *Conduit, name string) (Service, error) {
+	services, err := p.GetService(ctx, name)
+	if err != nil {
+		return Service{}, err
+	}
+	for _, service := range services {
+		if service.Fields.Name == name {
+			return service, nil
+		}
+	}
+	return Service{}, NewError(DataError, nil, "service %s is not found in Almanac", name)
+}
+
+// ensureDevice returns the device with the given name, it is created when it does not exist.
+func ensureDevice(ctx context.Context, p *Conduit, name string) (PropertyTarget, string, error) {
+	target, err := GetPropertyTarget(ctx, p, name, "")
+	if err == nil {
+		return target, StepExists, nil
+	}
+	if KindOf(err) != DataError {
+		return target, "", err
+	}
+	target, err = createDevice(ctx, p, name)
+	return target, StepCreated, err
+}
+
+// createDevice creates the device with the given name.
+func createDevice(ctx context.Context, p *Conduit, name string) (PropertyTarget, error) {
+	phid, err := p.Edit(ctx, "device", "", name, []map[string]interface{}{{"type": "name", "value": name}})
+	if err != nil {
+		return PropertyTarget{}, err
+	}
+	logger.Info("device created", "device", name, "phid", phid)
+	return PropertyTarget{Kind: "device", Name: name, PHID: phid}, nil
+}
+
+// ensureInterface returns the interface of the device with the address and port in the network,
+// it is created when the device has no such interface.
+func ensureInterface(ctx context.Context, p *Conduit, device PropertyTarget, networkPHID string, address string, port int) (string, string, error) {
+	phid, err := findInterface(ctx, p, device, networkPHID, address, port)
+	if err != nil || phid != "" {
+		return phid, StepExists, err
+	}
+	phid, err = createInterface(ctx, p, device, networkPHID, address, port)
+	return phid, StepCreated, err
+}
+
+// findInterface returns the PHID of the interface of the device with the address and port in the
+// network, it is empty when there is none.
+func findInterface(ctx context.Context, p *Conduit, device PropertyTarget, networkPHID string, address string, port int) (string, error) {
+	interfaces, err := p.GetInterfaces(ctx, device.PHID, device.Name)
+	if err != nil {
+		return "", err
+	}
+	for _, found := range interfaces {
+		if found.Fields.NetworkPHID == networkPHID && found.Fields.Address == address && found.Fields.Port == port {
+			return found.PHID, nil
+		}
+	}
+	return "", nil
+}
+
+// createInterface adds an interface with the address and port in the network to the device.
+func createInterface(ctx context.Context, p *Conduit, device PropertyTarget, networkPHID string, address string, port int) (string, error) {
+	phid, err := p.Edit(ctx, "interface", "", "of "+device.Name, []map[string]interface{}{
+		{"type": "device", "value": device.PHID},
+		{"type": "network", "value": networkPHID},
+		{"type": "address", "value": address},
+		{"type": "port", "value": port},
+	})
+	if err != nil {
+		return "", err
+	}
+	logger.Info("interface created", "device", device.Name, "address", address, "port", port)
+	return phid, nil
+}
+
+// ensureBinding binds the interface to the service. A disabled binding is enabled again.
+func ensureBinding(ctx context.Context, p *Conduit, service Service, interfacePHID string, device string) (string, error) {
+	for _, binding := range service.Attachments.Bindings.Bindings {
+		if binding.Interface.PHID != interfacePHID {
+			continue
+		}
+		if !binding.Disabled {
+			return StepExists, nil
+		}
+		_, err := p.Edit(ctx, "binding", binding.PHID, service.Fields.Name+" "+device, []map[string]interface{}{{"type": "disabled", "value": false}})
+		if err != nil {
+			return "", err
+		}
+		logger.Info("binding enabled", "service", service.Fields.Name, "device", device)
+		return StepEnabled, nil
+	}
+	return StepCreated, createBinding(ctx, p, service.PHID, service.Fields.Name, interfacePHID, device)
+}
+
+// createBinding binds the interface of the device to the service.
+func createBinding(ctx context.Context, p *Conduit, servicePHID string, service string, interfacePHID string, device string) error {
+	_, err := p.Edit(ctx, "binding", "", service+" "+device, []map[string]interface{}{
+		{"type": "service", "value": servicePHID},
+		{"type": "interface", "value": interfacePHID},
+	})
+	if err == nil {
+		logger.Info("binding created", "service", service, "device", device)
+	}
+	return err
+}
+
+// PrintRegisterSteps prints a line for every step.
+func PrintRegisterSteps(w io.Writer, steps []RegisterStep) {
+	for _, step := range steps {
+		fmt.Fprintf(w, "%-9s %-30s %s\n", step.Object, step.Name, step.Action)
+	}
+}
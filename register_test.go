@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRegisterHost(t *testing.T) {
+	responses := map[string]string{
+		"almanac.network.search":   `{"data":[{"phid":"PHID-ANET-1","fields":{"name":"dmz"}}]}`,
+		"almanac.service.search":   `{"data":[{"phid":"PHID-ASRV-1","fields":{"name":"web"},"attachments":{"bindings":{"bindings":[]}}}]}`,
+		"almanac.device.search":    `{"data":[]}`,
+		"almanac.interface.search": `{"data":[],"cursor":{"after":null}}`,
+	}
+	var edits []map[string]interface{}
+	server := editServer(t, responses, &edits)
+	defer server.Close()
+	p := NewConduit(server.URL, "api-token")
+	options := RegisterOptions{
+		Name: "web01", Address: "10.0.0.5", Port: 22, Network: "dmz", Services: []string{"web"},
+		Properties: map[string]string{"http_port": "8080"},
+	}
+
+	steps, err := RegisterHost(context.Background(), p, defaultConfig(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RegisterStep{
+		{"device", "web01", StepCreated},
+		{"interface", "10.0.0.5:22 in dmz", StepCreated},
+		{"binding", "web", StepCreated},
+		{"property", "http-port", StepSet},
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("unexpected steps %v", steps)
+	}
+	var methods []string
+	for _, edit := range edits {
+		methods = append(methods, edit["method"].(string))
+	}
+	if !reflect.DeepEqual(methods, []string{"almanac.device.edit", "almanac.interface.edit", "almanac.binding.edit", "almanac.device.edit"}) {
+		t.Errorf("unexpected edits %v", edits)
+	}
+
+	responses["almanac.device.search"] = `{"data":[{"phid":"PHID-ADEV-1","fields":{"name":"web01"},"attachments":{"properties":{"properties":[{"key":"http-port","value":"8080"}]}}}]}`
+	responses["almanac.interface.search"] = `{"data":[{"phid":"PHID-AINT-1","fields":{"devicePHID":"PHID-ADEV-1","networkPHID":"PHID-ANET-1","address":"10.0.0.5","port":22}}],"cursor":{"after":null}}`
+	responses["almanac.service.search"] = `{"data":[{"phid":"PHID-ASRV-1","fields":{"name":"web"},"attachments":{"bindings":{"bindings":[{"phid":"PHID-ABND-1","disabled":true,"interface":{"phid":"PHID-AINT-1"}}]}}}]}`
+	edits = nil
+	steps, err = RegisterHost(context.Background(), p, defaultConfig(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []RegisterStep{
+		{"device", "web01", StepExists},
+		{"interface", "10.0.0.5:22 in dmz", StepExists},
+		{"binding", "web", StepEnabled},
+		{"property", "http-port", StepUnchanged},
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("unexpected steps of the second run %v", steps)
+	}
+	if len(edits) != 1 || edits[0]["objectIdentifier"] != "PHID-ABND-1" {
+		t.Errorf("only the binding should be enabled, got %v", edits)
+	}
+
+	options.Network = "lan"
+	if _, err = RegisterHost(context.Background(), p, defaultConfig(), options); ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data error for an unknown network, got %v", err)
+	}
+}
+
+func TestParsePropertyFlags(t *testing.T) {
+	properties, err := ParsePropertyFlags([]string{"db_user=root", "dsn=a=b"})
+	if err != nil || !reflect.DeepEqual(properties, map[string]string{"db_user": "root", "dsn": "a=b"}) {
+		t.Errorf("unexpected properties %v %v", properties, err)
+	}
+	if _, err = ParsePropertyFlags([]string{"db_user"}); ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error, got %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+// The special properties prometheus-config, blackbox-config and alertmanager-config are json read
+// by the monitoring modes. Their bundled JSON Schemas are checked before the values are used, so a
+// broken value fails with the exact place of the mistake instead of a panic or a skipped entry.
+// Only the parts of JSON Schema that the bundled schemas use are implemented.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const prometheusSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "prometheus-config",
+  "description": "The Prometheus jobs scraped on the hosts of a service or on a single device.",
+  "type": "array",
+  "items": {
+    "type": "object",
+    "required": ["name", "port"],
+    "properties": {
+      "name": {"type": "string", "minLength": 1, "description": "The job label of the scrape target."},
+      "port": {"type": "integer", "minimum": 1, "maximum": 65535, "description": "The port of the exporter on the host."},
+      "exporter": {"type": "string", "description": "The name of the exporter, only for the readers of the property."}
+    }
+  }
+}`
+
+const blackboxSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "blackbox-config",
+  "description": "The blackbox exporter probes of the hosts of a service or of a single device.",
+  "type": "array",
+  "items": {
+    "type": "object",
+    "required": ["module", "targets"],
+    "properties": {
+      "module": {"type": "string", "minLength": 1, "description": "The blackbox exporter module used for the probe."},
+      "targets": {"type": "array", "minItems": 1, "items": {"type": "string", "minLength": 1}, "description": "The probed targets."}
+    }
+  }
+}`
+
+const alertManagerSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "alertmanager-config",
+  "description": "The Alertmanager receivers of the alerts of a service.",
+  "type": "array",
+  "items": {
+    "type": "object",
+    "required": ["name", "type", "receiver-config"],
+    "properties": {
+      "name": {"type": "string", "minLength": 1, "description": "The name of the receiver, unique within the service."},
+      "type": {"type": "string", "minLength": 1, "description": "The type of the receiver, only email receivers get a configuration."},
+      "receiver-config": {"type": "object", "description": "The configuration of the receiver, its keys depend on the type."},
+      "matching-config": {
+        "type": "object",
+        "additionalProperties": {"type": "string"},
+        "description": "Extra labels the alerts must match, the group label can not be changed."
+      }
+    },
+    "if": {"required": ["type"], "properties": {"type": {"enum": ["email"]}}},
+    "then": {
+      "properties": {
+        "receiver-config": {
+          "properties": {
+            "to": {"type": "string", "description": "The email address the alerts are sent to."},
+            "text": {"type": "string", "description": "The body of the email."},
+            "require-tls": {"type": ["boolean", "string"], "enum": [true, false, "true", "false"], "description": "Whether the mail server needs TLS, false by default."},
+            "send-resolved": {"type": ["boolean", "string"], "enum": [true, false, "true", "false"], "description": "Whether the resolved alerts are sent, true by default."}
+          },
+          "additionalProperties": false
+        }
+      }
+    }
+  }
+}`
+
+// propertySchemas are the bundled schemas by the inventory name of the property.
+var propertySchemas = map[string]string{
+	"prometheus_config":   prometheusSchema,
+	"blackbox_config":     blackboxSchema,
+	"alertmanager_config": alertManagerSchema,
+}
+
+// parsedSchemas are the bundled schemas ready for validation.
+var parsedSchemas = parseSchemas(propertySchemas)
+
+// Schema is a JSON Schema. The booleans true and false are the schemas that allow everything and nothing.
+type Schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *Schema            `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinItems             *int               `json:"minItems"`
+	MinLength            *int               `json:"minLength"`
+	// Then is checked when the value matches If.
+	If    *Schema `json:"if"`
+	Then  *Schema `json:"then"`
+	never bool
+}
+
+// schemaTypes is the type keyword, a single type or a list of them.
+type schemaTypes []string
+
+// UnmarshalJSON reads a single type or a list of them.
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// UnmarshalJSON reads a schema object or one of the boolean schemas.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err == nil {
+		*s = Schema{never: !allowed}
+		return nil
+	}
+	type plain Schema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// SchemaError is a value that does not match its schema, the path is empty for the value itself.
+type SchemaError struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func (e SchemaError) Error() string {
+	if e.Path == "" {
+		return "the value " + e.Reason
+	}
+	return e.Path + " " + e.Reason
+}
+
+// parseSchemas parses the bundled schemas, they are part of a2a so a broken one is a bug.
+func parseSchemas(sources map[string]string) map[string]*Schema {
+	schemas := make(map[string]*Schema, len(sources))
+	for key, source := range sources {
+		var schema Schema
+		if err := json.Unmarshal([]byte(source), &schema); err != nil {
+			panic(fmt.Sprintf("invalid bundled schema %s: %v", key, err))
+		}
+		schemas[key] = &schema
+	}
+	return schemas
+}
+
+// SchemaNames returns the dashed names of the properties with a schema.
+func SchemaNames() []string {
+	names := make([]string, 0, len(propertySchemas))
+	for key := range propertySchemas {
+		names = append(names, strings.ReplaceAll(key, "_", "-"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PropertySchema returns the bundled schema of the property, the name is dashed or underscored.
+func PropertySchema(name string) (string, error) {
+	source, found := propertySchemas[ReplaceToUnderscore(name)]
+	if !found {
+		return "", NewError(ConfigError, nil, "%s has no schema, known are %s", name, strings.Join(SchemaNames(), ", "))
+	}
+	return source, nil
+}
+
+// ValidateProperty checks the decoded value of a special property against its schema. Properties
+// without a schema are always valid.
+func ValidateProperty(key string, value interface{}) []SchemaError {
+	schema, found := parsedSchemas[ReplaceToUnderscore(key)]
+	if !found {
+		return nil
+	}
+	return schema.Validate(value)
+}
+
+// decodeMonitoringConfig decodes the json of a special property to target and checks it against
+// its schema. isJson is false for a value that does not match the json wrapper.
+func decodeMonitoringConfig(key string, value string, jsonWrapper string, target interface{}) (isJson bool, err error) {
+	decoded, isJson, err := HandleJson(jsonWrapper, value)
+	if err != nil || !isJson {
+		return isJson, err
+	}
+	if err = validationError(key, ValidateProperty(key, decoded)); err != nil {
+		return isJson, err
+	}
+	return isJson, json.Unmarshal([]byte(value), target)
+}
+
+// validationError combines the schema errors of a property to one error.
+func validationError(key string, problems []SchemaError) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	name := strings.ReplaceAll(key, "_", "-")
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, name+problem.Path+" "+problem.Reason)
+	}
+	return fmt.Errorf("%s", strings.Join(messages, "; "))
+}
+
+// Validate returns all the places where the value does not match the schema.
+func (s *Schema) Validate(value interface{}) []SchemaError {
+	var problems []SchemaError
+	s.validate(value, "", &problems)
+	return problems
+}
+
+func (s *Schema) validate(value interface{}, path string, problems *[]SchemaError) {
+	add := func(format string, args ...interface{}) {
+		*problems = append(*problems, SchemaError{Path: path, Reason: fmt.Sprintf(format, args...)})
+	}
+	if s.never {
+		add("is not allowed")
+		return
+	}
+	if len(s.Type) > 0 && !s.Type.match(value) {
+		add("must be %s", s.Type.describe())
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		allowed := make([]string, 0, len(s.Enum))
+		for _, option := range s.Enum {
+			encoded, _ := json.Marshal(option)
+			allowed = append(allowed, string(encoded))
+		}
+		add("must be one of %s", strings.Join(allowed, ", "))
+		return
+	}
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			add("must be at least %g", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			add("must be at most %g", *s.Maximum)
+		}
+	case string:
+		if s.MinLength != nil && len([]rune(v)) < *s.MinLength {
+			if *s.MinLength == 1 {
+				add("must not be empty")
+			} else {
+				add("must have at least %d characters", *s.MinLength)
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			add("must have at least %d items", *s.MinItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case map[string]interface{}:
+		for _, field := range s.Required {
+			if _, found := v[field]; !found {
+				*problems = append(*problems, SchemaError{Path: path + pathKey(field), Reason: "is required"})
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, known := s.Properties[key]
+			if !known {
+				field = s.AdditionalProperties
+			}
+			if field != nil {
+				field.validate(v[key], path+pathKey(key), problems)
+			}
+		}
+	}
+	if s.If != nil && s.Then != nil && len(s.If.Validate(value)) == 0 {
+		s.Then.validate(value, path, problems)
+	}
+}
+
+// UnknownKeys returns the keys of the objects in the value of a special property that its schema
+// does not name. They are allowed, but can be typos of the known keys.
+func UnknownKeys(key string, value interface{}) []SchemaError {
+	schema, found := parsedSchemas[ReplaceToUnderscore(key)]
+	if !found {
+		return nil
+	}
+	var unknown []SchemaError
+	schema.unknownKeys(value, "", &unknown)
+	return unknown
+}
+
+func (s *Schema) unknownKeys(value interface{}, path string, unknown *[]SchemaError) {
+	switch v := value.(type) {
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.unknownKeys(item, fmt.Sprintf("%s[%d]", path, i), unknown)
+			}
+		}
+	case map[string]interface{}:
+		// The objects with free keys, like receiver-config of other types than email, are skipped.
+		if s.AdditionalProperties != nil || len(s.Properties) == 0 {
+			return
+		}
+		for _, key := range sortedKeys(v) {
+			if field, known := s.Properties[key]; known {
+				field.unknownKeys(v[key], path+pathKey(key), unknown)
+			} else {
+				*unknown = append(*unknown, SchemaError{Path: path + pathKey(key), Reason: "is not in the schema"})
+			}
+		}
+	}
+}
+
+// pathKeyName are the keys written with a dot in the error paths.
+var pathKeyName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// pathKey returns the path element of an object key.
+func pathKey(key string) string {
+	if pathKeyName.MatchString(key) {
+		return "." + key
+	}
+	encoded, _ := json.Marshal(key)
+	return "[" + string(encoded) + "]"
+}
+
+// match checks the json type of a decoded value.
+func (t schemaTypes) match(value interface{}) bool {
+	for _, name := range t {
+		switch v := value.(type) {
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case float64:
+			if name == "number" || name == "integer" && v == math.Trunc(v) {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe returns the types for an error message, ex. "a string or a boolean".
+func (t schemaTypes) describe() string {
+	described := make([]string, 0, len(t))
+	for _, name := range t {
+		switch name {
+		case "array", "object", "integer":
+			described = append(described, "an "+name)
+		case "null":
+			described = append(described, "null")
+		default:
+			described = append(described, "a "+name)
+		}
+	}
+	return strings.Join(described, " or ")
+}
+
+// inEnum checks if the value is one of the allowed values.
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, option := range enum {
+		if reflect.DeepEqual(option, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateProperty(t *testing.T) {
+	tests := []struct {
+		key      string
+		value    string
+		expected []string
+	}{
+		{"prometheus_config", `[{"name":"node","port":9100}]`, nil},
+		{"prometheus_config", `[{"name":"node","port":9100,"path":"/metrics"}]`, nil},
+		{"prometheus_config", `{"name":"node"}`, []string{"the value must be an array"}},
+		{"prometheus_config", `[{"name":"node","port":"9100"},{"port":70000,"prot":1}]`, []string{
+			"[0].port must be an integer",
+			"[1].name is required",
+			"[1].port must be at most 65535",
+		}},
+		{"blackbox_config", `[{"module":"http_2xx","targets":[]}]`, []string{"[0].targets must have at least 1 items"}},
+		{"alertmanager_config", `[{"name":"ops","type":"email","receiver-config":{"to":"ops@example.com","require-tls":"yes"},"matching-config":{"severity":2}}]`, []string{
+			`[0].matching-config.severity must be a string`,
+			`[0].receiver-config.require-tls must be one of true, false, "true", "false"`,
+		}},
+		{"alertmanager_config", `[{"name":"ops","type":"email","receiver-config":{"url":"https://hooks.example"}}]`, []string{
+			"[0].receiver-config.url is not allowed",
+		}},
+		{"alertmanager_config", `[{"name":"hook","type":"webhook","receiver-config":{"url":"https://hooks.example"}}]`, nil},
+		{"db_user", `[1]`, nil},
+	}
+	for _, test := range tests {
+		var value interface{}
+		if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+			t.Fatal(err)
+		}
+		var found []string
+		for _, problem := range ValidateProperty(test.key, value) {
+			found = append(found, problem.Error())
+		}
+		if !reflect.DeepEqual(found, test.expected) {
+			t.Errorf("%s %s: unexpected problems %q", test.key, test.value, found)
+		}
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	var prometheus, alertManager interface{}
+	json.Unmarshal([]byte(`[{"name":"node","port":9100,"prot":1}]`), &prometheus)
+	json.Unmarshal([]byte(`[{"name":"hook","type":"webhook","receiver-config":{"url":"https://hooks.example"}}]`), &alertManager)
+	unknown := UnknownKeys("prometheus-config", prometheus)
+	if len(unknown) != 1 || unknown[0].Error() != "[0].prot is not in the schema" {
+		t.Errorf("expected the typo as unknown key, got %v", unknown)
+	}
+	if unknown = UnknownKeys("alertmanager-config", alertManager); len(unknown) != 0 {
+		t.Errorf("the receiver-config of a webhook has free keys, got %v", unknown)
+	}
+}
+
+func TestPropertySchema(t *testing.T) {
+	for _, name := range []string{"prometheus-config", "blackbox_config", "alertmanager-config"} {
+		source, err := PropertySchema(name)
+		if err != nil || !json.Valid([]byte(source)) {
+			t.Errorf("expected the schema of %s, got %v", name, err)
+		}
+	}
+	_, err := PropertySchema("db-user")
+	if ExitCode(err) != exitCodes[ConfigError] || !strings.Contains(err.Error(), "alertmanager-config, blackbox-config, prometheus-config") {
+		t.Errorf("expected a configuration error with the known properties, got %v", err)
+	}
+}
+
+func TestGetPrometheusDataInvalid(t *testing.T) {
+	var output Output
+	output.Group = map[string]Group{
+		"web": {
+			Hosts:     []string{"web01"},
+			Vars:      map[string]interface{}{"prometheus_config": `[{"name":"node","port":9100}]`},
+			Addresses: map[string]string{"web01": "10.0.0.1", "web02": "10.0.0.2"},
+		},
+	}
+	output.Meta.HostVars = map[string]map[string]interface{}{"web02": {"prometheus_config": `[{"name":"node","port":"9100"}]`}}
+	output.Group["web"] = Group{Hosts: []string{"web01", "web02"}, Vars: output.Group["web"].Vars, Addresses: output.Group["web"].Addresses}
+	outputs, problems := GetPrometheusData(output, testJsonWrapper, nil)
+	if len(outputs) != 1 || !reflect.DeepEqual(outputs[0].Targets, []string{"10.0.0.1:9100"}) {
+		t.Errorf("the valid host should be kept, got %v", outputs)
+	}
+	if len(problems) != 1 || problems[0].Device != "web02" || !strings.Contains(problems[0].Reason, "prometheus-config[0].port must be an integer") {
+		t.Errorf("expected a problem with the path for web02, got %v", problems)
+	}
+	if err := reportProblems(problems, 0); ExitCode(err) != exitCodes[DataError] {
+		t.Errorf("expected a data error above Inventory.MaxErrors, got %v", err)
+	}
+	if err := reportProblems(problems, -1); err != nil {
+		t.Errorf("expected no error without a limit, got %v", err)
+	}
+}
+
+func TestGetGroupRouteReceivers(t *testing.T) {
+	var output Output
+	output.Group = map[string]Group{
+		"web": {Vars: map[string]interface{}{"alertmanager_config": `[` +
+			`{"name":"ops","type":"email","receiver-config":{"to":"ops@example.com","require-tls":true,"send-resolved":"false"},` +
+			`"matching-config":{"severity":"critical","group":"other"}},` +
+			`{"name":"dev","type":"email","receiver-config":{}}]`}},
+	}
+	routes, receivers, problems := getGroupRouteReceivers(output, testJsonWrapper)
+	if len(problems) != 0 {
+		t.Fatal(problems)
+	}
+	if len(routes) != 2 || !reflect.DeepEqual(routes[0].Match, map[string]string{"group": "web", "severity": "critical"}) {
+		t.Errorf("unexpected routes %v", routes)
+	}
+	if !reflect.DeepEqual(routes[1].Match, map[string]string{"group": "web"}) {
+		t.Errorf("the matching config should not leak into the next route, got %v", routes[1].Match)
+	}
+	email := receivers[0].EmailConfigs[0]
+	if receivers[0].Name != "dynamic-web-email-ops" || email.To != "ops@example.com" || !*email.RequireTLS || email.VSendResolved {
+		t.Errorf("unexpected receiver %v %v", receivers[0], email)
+	}
+	if defaults := receivers[1].EmailConfigs[0]; *defaults.RequireTLS || !defaults.VSendResolved {
+		t.Errorf("unexpected defaults %v", defaults)
+	}
+
+	output.Group["db"] = Group{Vars: map[string]interface{}{"alertmanager_config": `[{"name":"ops","type":"email"}]`}}
+	routes, _, problems = getGroupRouteReceivers(output, testJsonWrapper)
+	if len(problems) != 1 || problems[0].Group != "db" || len(routes) != 2 {
+		t.Errorf("expected the invalid group to be skipped, got %v %v", routes, problems)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+// The snapshots are the inventories of the earlier runs with the passphrases redacted. They are
+// written to Snapshot.Directory when the inventory changed and a2a diff compares two of them, so
+// the changes in Almanac can be found when a playbook suddenly targets other hosts.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotTimeFormat is the name of the snapshot files, they are sorted by their time.
+const snapshotTimeFormat = "20060102T150405Z"
+
+// minContainedSecret is the length of a secret from which the strings that contain it are
+// redacted too. Shorter secrets like 1 would redact most of the snapshot, only equal values are
+// redacted for them.
+const minContainedSecret = 8
+
+// secrets are the resolved passphrases of the run, they are redacted in the snapshots.
+var secrets = struct {
+	sync.Mutex
+	values map[string]bool
+}{values: make(map[string]bool)}
+
+// rememberSecret adds a resolved passphrase to the secrets of the run.
+func rememberSecret(value string) {
+	if value == "" {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values[value] = true
+}
+
+// redactSecrets replaces the strings which are a secret or contain a long secret with redactedValue.
+func redactSecrets(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case string:
+		secrets.Lock()
+		defer secrets.Unlock()
+		if secrets.values[typed] {
+			return redactedValue
+		}
+		for secret := range secrets.values {
+			if len(secret) >= minContainedSecret && strings.Contains(typed, secret) {
+				return redactedValue
+			}
+		}
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = redactSecrets(item)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = redactSecrets(item)
+		}
+	}
+	return value
+}
+
+// Snapshot is a snapshot file.
+type Snapshot struct {
+	Name string
+	Path string
+	Time time.Time
+}
+
+// SnapshotData is the inventory of a snapshot.
+type SnapshotData struct {
+	Groups   map[string]SnapshotGroup
+	HostVars map[string]map[string]interface{}
+}
+
+// SnapshotGroup is a group of a snapshot.
+type SnapshotGroup struct {
+	Hosts []string               `json:"hosts"`
+	Vars  map[string]interface{} `json:"vars"`
+}
+
+// ListSnapshots returns the snapshots of the directory from the oldest to the newest.
+func ListSnapshots(dir string) (snapshots []Snapshot, err error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return snapshots, NewError(ConfigError, err, "can not list the snapshots")
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		created, err := time.Parse(snapshotTimeFormat, name)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Name: name, Path: path, Time: created})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// SaveSnapshot writes the inventory with the secrets redacted to the directory, when it differs
+// from the newest snapshot. The oldest snapshots are removed so only keep are left.
+func SaveSnapshot(dir string, jsonData []byte, keep int, now time.Time) (saved bool, err error) {
+	var data interface{}
+	err = json.Unmarshal(jsonData, &data)
+	if err != nil {
+		return false, NewError(OutputError, err, "can not read the inventory for the snapshot")
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(redactSecrets(data))
+	if err != nil {
+		return false, NewError(OutputError, err, "can not encode the snapshot")
+	}
+	redacted := buffer.Bytes()
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return false, NewError(OutputError, err, "can not create the snapshot directory")
+	}
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return false, err
+	}
+	if len(snapshots) > 0 {
+		newest, err := ioutil.ReadFile(snapshots[len(snapshots)-1].Path)
+		if err == nil && bytes.Equal(newest, redacted) {
+			return false, nil
+		}
+	}
+	path := filepath.Join(dir, now.UTC().Format(snapshotTimeFormat)+".json")
+	err = ioutil.WriteFile(path, redacted, 0600)
+	if err != nil {
+		return false, NewError(OutputError, err, "can not write the snapshot %s", path)
+	}
+	snapshots = append(snapshots, Snapshot{Path: path})
+	for keep > 0 && len(snapshots) > keep {
+		if err := os.Remove(snapshots[0].Path); err != nil {
+			logger.Warn("can not remove the old snapshot", "file", snapshots[0].Path, "error", err)
+		}
+		snapshots = snapshots[1:]
+	}
+	return true, nil
+}
+
+// ReadSnapshot reads the inventory of the snapshot at path.
+func ReadSnapshot(path string) (data SnapshotData, err error) {
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return data, NewError(ConfigError, err, "can not read the snapshot")
+	}
+	var items map[string]json.RawMessage
+	err = json.Unmarshal(buffer, &items)
+	if err != nil {
+		return data, NewError(DataError, err, "can not parse the snapshot %s", path)
+	}
+	data.Groups = make(map[string]SnapshotGroup)
+	for name, item := range items {
+		if name == "_meta" {
+			var meta struct {
+				HostVars map[string]map[string]interface{} `json:"hostvars"`
+			}
+			err = json.Unmarshal(item, &meta)
+			data.HostVars = meta.HostVars
+		} else {
+			var group SnapshotGroup
+			err = json.Unmarshal(item, &group)
+			data.Groups[name] = group
+		}
+		if err != nil {
+			return data, NewError(DataError, err, "can not parse %s in the snapshot %s", name, path)
+		}
+	}
+	return data, nil
+}
+
+// FindSnapshot returns the path of the snapshot with the given name or path.
+func FindSnapshot(dir string, name string) (string, error) {
+	for _, path := range []string{name, filepath.Join(dir, name), filepath.Join(dir, name+".json")} {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", NewError(ConfigError, nil, "the snapshot %s is not found in %s", name, dir)
+}
+
+// SnapshotsSince returns the newest snapshot that is older than the given age and the newest
+// snapshot. Without an age the two newest snapshots are returned.
+func SnapshotsSince(snapshots []Snapshot, age time.Duration, now time.Time) (from Snapshot, to Snapshot, err error) {
+	if len(snapshots) < 2 {
+		return from, to, NewError(DataError, nil, "at least two snapshots are needed, found %d", len(snapshots))
+	}
+	to = snapshots[len(snapshots)-1]
+	from = snapshots[len(snapshots)-2]
+	if age > 0 {
+		from = snapshots[0]
+		for _, snapshot := range snapshots[:len(snapshots)-1] {
+			if !snapshot.Time.After(now.Add(-age)) {
+				from = snapshot
+			}
+		}
+	}
+	return from, to, nil
+}
+
+// ParseAge reads an age like 2d, 12h or 30m.
+func ParseAge(text string) (time.Duration, error) {
+	if days := strings.TrimSuffix(text, "d"); days != text {
+		count, err := strconv.Atoi(days)
+		if err == nil && count >= 0 {
+			return time.Duration(count) * 24 * time.Hour, nil
+		}
+	}
+	age, err := time.ParseDuration(text)
+	if err != nil || age < 0 {
+		return 0, NewError(ConfigError, err, "invalid age %q, use for example 2d or 12h", text)
+	}
+	return age, nil
+}
+
+// VarChange is a changed variable, + for an added, - for a removed and ~ for a changed one.
+type VarChange struct {
+	Change string      `json:"change"`
+	Var    string      `json:"var"`
+	Old    interface{} `json:"old,omitempty"`
+	New    interface{} `json:"new,omitempty"`
+}
+
+// InventoryDiff are the changes between two snapshots.
+type InventoryDiff struct {
+	Groups []GroupDiff `json:"groups,omitempty"`
+	Hosts  []HostDiff  `json:"hosts,omitempty"`
+}
+
+// GroupDiff are the changes of a group.
+type GroupDiff struct {
+	Name         string      `json:"name"`
+	Change       string      `json:"change"`
+	AddedHosts   []string    `json:"added_hosts,omitempty"`
+	RemovedHosts []string    `json:"removed_hosts,omitempty"`
+	Vars         []VarChange `json:"vars,omitempty"`
+}
+
+// HostDiff are the changes of the variables of a host.
+type HostDiff struct {
+	Name   string      `json:"name"`
+	Change string      `json:"change"`
+	Vars   []VarChange `json:"vars,omitempty"`
+}
+
+// DiffSnapshots returns the changes from the snapshot from to the snapshot to.
+func DiffSnapshots(from SnapshotData, to SnapshotData) (diff InventoryDiff) {
+	groups := make(map[string]bool)
+	for name := range from.Groups {
+		groups[name] = true
+	}
+	for name := range to.Groups {
+		groups[name] = true
+	}
+	for _, name := range sortedKeys(groups) {
+		old, inOld := from.Groups[name]
+		current, inNew := to.Groups[name]
+		group := GroupDiff{Name: name, Change: changeOf(inOld, inNew)}
+		for _, host := range current.Hosts {
+			if !containsString(old.Hosts, host) {
+				group.AddedHosts = append(group.AddedHosts, host)
+			}
+		}
+		for _, host := range old.Hosts {
+			if !containsString(current.Hosts, host) {
+				group.RemovedHosts = append(group.RemovedHosts, host)
+			}
+		}
+		group.Vars = diffVars(old.Vars, current.Vars)
+		if group.Change != "~" || len(group.AddedHosts) > 0 || len(group.RemovedHosts) > 0 || len(group.Vars) > 0 {
+			diff.Groups = append(diff.Groups, group)
+		}
+	}
+
+	hosts := make(map[string]bool)
+	for name := range from.HostVars {
+		hosts[name] = true
+	}
+	for name := range to.HostVars {
+		hosts[name] = true
+	}
+	for _, name := range sortedKeys(hosts) {
+		old, inOld := from.HostVars[name]
+		current, inNew := to.HostVars[name]
+		host := HostDiff{Name: name, Change: changeOf(inOld, inNew), Vars: diffVars(old, current)}
+		if host.Change != "~" || len(host.Vars) > 0 {
+			diff.Hosts = append(diff.Hosts, host)
+		}
+	}
+	return diff
+}
+
+// changeOf returns +, - or ~ for an item that is in the old or the new snapshot.
+func changeOf(inOld bool, inNew bool) string {
+	switch {
+	case !inOld:
+		return "+"
+	case !inNew:
+		return "-"
+	}
+	return "~"
+}
+
+// diffVars returns the changed variables.
+func diffVars(old map[string]interface{}, current map[string]interface{}) (changes []VarChange) {
+	keys := make(map[string]bool)
+	for key := range old {
+		keys[key] = true
+	}
+	for key := range current {
+		keys[key] = true
+	}
+	for _, key := range sortedKeys(keys) {
+		oldValue, inOld := old[key]
+		newValue, inNew := current[key]
+		if inOld && inNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, VarChange{Change: changeOf(inOld, inNew), Var: key, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+// PrintDiff prints the changes, the hosts of the groups and the variables.
+func PrintDiff(w io.Writer, diff InventoryDiff) {
+	if len(diff.Groups) == 0 && len(diff.Hosts) == 0 {
+		fmt.Fprintln(w, "no changes")
+		return
+	}
+	printVars := func(changes []VarChange) {
+		for _, change := range changes {
+			switch change.Change {
+			case "+":
+				fmt.Fprintf(w, "  + %s: %s\n", change.Var, jsonText(change.New))
+			case "-":
+				fmt.Fprintf(w, "  - %s: %s\n", change.Var, jsonText(change.Old))
+			default:
+				fmt.Fprintf(w, "  ~ %s: %s -> %s\n", change.Var, jsonText(change.Old), jsonText(change.New))
+			}
+		}
+	}
+	for _, group := range diff.Groups {
+		fmt.Fprintf(w, "%s group %s\n", group.Change, group.Name)
+		for _, host := range group.AddedHosts {
+			fmt.Fprintf(w, "  + host %s\n", host)
+		}
+		for _, host := range group.RemovedHosts {
+			fmt.Fprintf(w, "  - host %s\n", host)
+		}
+		printVars(group.Vars)
+	}
+	for _, host := range diff.Hosts {
+		fmt.Fprintf(w, "%s host %s\n", host.Change, host.Name)
+		printVars(host.Vars)
+	}
+}
+
+// jsonText returns the value as json without the html escaping.
+func jsonText(value interface{}) string {
+	var text bytes.Buffer
+	encoder := json.NewEncoder(&text)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSpace(text.String())
+}
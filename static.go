@@ -0,0 +1,365 @@
+package main
+
+// Static Ansible inventories are read for a2a import. Both formats of Ansible are supported,
+// INI and YAML, with the group_vars and host_vars folders next to the inventory file.
+
+import (
+	"bufio"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StaticInventory is a static Ansible inventory with the variables of its groups and hosts.
+type StaticInventory struct {
+	Groups map[string]*StaticGroup
+	// Hosts are the variables of every host.
+	Hosts map[string]map[string]interface{}
+}
+
+// StaticGroup is a group of a static inventory.
+type StaticGroup struct {
+	Hosts    []string
+	Children []string
+	Vars     map[string]interface{}
+}
+
+// yamlInventoryGroup is a group of the YAML inventory format.
+type yamlInventoryGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*yamlInventoryGroup    `yaml:"children"`
+}
+
+// hostRange is a host pattern with a range, ex. web[01:03] or db-[a:c].
+var hostRange = regexp.MustCompile(`^(.*)\[([0-9]+|[a-z]):([0-9]+|[a-z])\](.*)$`)
+
+// ReadStaticInventory reads the inventory file and the group_vars and host_vars next to it.
+// Files ending with .yml, .yaml or .json are read as YAML, all the others as INI.
+func ReadStaticInventory(path string) (*StaticInventory, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, NewError(ConfigError, err, "can not read the inventory %s", path)
+	}
+	inventory := &StaticInventory{Groups: make(map[string]*StaticGroup), Hosts: make(map[string]map[string]interface{})}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml", ".json":
+		err = inventory.parseYaml(content)
+	default:
+		err = inventory.parseIni(string(content))
+	}
+	if err != nil {
+		return nil, NewError(ConfigError, err, "invalid inventory %s", path)
+	}
+	dir := filepath.Dir(path)
+	for name, group := range inventory.Groups {
+		vars, err := readVarsFiles(filepath.Join(dir, "group_vars"), name)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range vars {
+			group.Vars[key] = value
+		}
+	}
+	for name, hostVars := range inventory.Hosts {
+		vars, err := readVarsFiles(filepath.Join(dir, "host_vars"), name)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range vars {
+			hostVars[key] = value
+		}
+	}
+	return inventory, nil
+}
+
+// group returns the group with the given name, it is added when it does not exist.
+func (inventory *StaticInventory) group(name string) *StaticGroup {
+	group, found := inventory.Groups[name]
+	if !found {
+		group = &StaticGroup{Vars: make(map[string]interface{})}
+		inventory.Groups[name] = group
+	}
+	return group
+}
+
+// addHost adds the host to the group and merges its variables.
+func (inventory *StaticInventory) addHost(groupName string, host string, vars map[string]interface{}) {
+	group := inventory.group(groupName)
+	if !containsString(group.Hosts, host) {
+		group.Hosts = append(group.Hosts, host)
+	}
+	hostVars, found := inventory.Hosts[host]
+	if !found {
+		hostVars = make(map[string]interface{})
+		inventory.Hosts[host] = hostVars
+	}
+	for key, value := range vars {
+		hostVars[key] = normalizeYaml(value)
+	}
+}
+
+// HostNames returns the sorted names of the hosts.
+func (inventory *StaticInventory) HostNames() []string {
+	names := make([]string, 0, len(inventory.Hosts))
+	for name := range inventory.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GroupNames returns the sorted names of the groups.
+func (inventory *StaticInventory) GroupNames() []string {
+	names := make([]string, 0, len(inventory.Groups))
+	for name := range inventory.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HostGroups returns the sorted groups of the host. A host of a child group is in the parent
+// groups too, all and ungrouped are left out.
+func (inventory *StaticInventory) HostGroups(host string) []string {
+	parents := make(map[string][]string)
+	for name, group := range inventory.Groups {
+		for _, child := range group.Children {
+			parents[child] = append(parents[child], name)
+		}
+	}
+	found := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if found[name] {
+			return
+		}
+		found[name] = true
+		for _, parent := range parents[name] {
+			visit(parent)
+		}
+	}
+	for name, group := range inventory.Groups {
+		if containsString(group.Hosts, host) {
+			visit(name)
+		}
+	}
+	groups := make([]string, 0, len(found))
+	for name := range found {
+		if name != "all" && name != "ungrouped" {
+			groups = append(groups, name)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// parseYaml reads the YAML inventory format, the top level are the groups, usually only all.
+func (inventory *StaticInventory) parseYaml(content []byte) error {
+	var groups map[string]*yamlInventoryGroup
+	if err := yaml.Unmarshal(content, &groups); err != nil {
+		return err
+	}
+	for name, group := range groups {
+		inventory.addYamlGroup(name, group)
+	}
+	return nil
+}
+
+// addYamlGroup adds the group with its hosts, variables and children.
+func (inventory *StaticInventory) addYamlGroup(name string, yamlGroup *yamlInventoryGroup) {
+	group := inventory.group(name)
+	if yamlGroup == nil {
+		return
+	}
+	for host, vars := range yamlGroup.Hosts {
+		for _, expanded := range expandHostRange(host) {
+			inventory.addHost(name, expanded, vars)
+		}
+	}
+	for key, value := range yamlGroup.Vars {
+		group.Vars[key] = normalizeYaml(value)
+	}
+	for child, childGroup := range yamlGroup.Children {
+		if !containsString(group.Children, child) {
+			group.Children = append(group.Children, child)
+		}
+		inventory.addYamlGroup(child, childGroup)
+	}
+}
+
+// parseIni reads the INI inventory format with the [group], [group:vars] and [group:children] sections.
+func (inventory *StaticInventory) parseIni(content string) error {
+	section, kind := "ungrouped", "hosts"
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section, kind = strings.TrimSpace(line[1:len(line)-1]), "hosts"
+			if name, suffix, found := strings.Cut(section, ":"); found {
+				section, kind = name, suffix
+			}
+			if kind != "hosts" && kind != "vars" && kind != "children" {
+				return fmt.Errorf("line %d: unknown section type %s", number, kind)
+			}
+			inventory.group(section)
+			continue
+		}
+		fields, err := splitIniLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", number, err)
+		}
+		switch kind {
+		case "hosts":
+			vars := make(map[string]interface{})
+			for _, field := range fields[1:] {
+				key, value, found := strings.Cut(field, "=")
+				if !found {
+					return fmt.Errorf("line %d: invalid variable %s, use key=value", number, field)
+				}
+				vars[key] = value
+			}
+			for _, host := range expandHostRange(fields[0]) {
+				inventory.addHost(section, host, vars)
+			}
+		case "children":
+			group := inventory.group(section)
+			if !containsString(group.Children, fields[0]) {
+				group.Children = append(group.Children, fields[0])
+			}
+			inventory.group(fields[0])
+		case "vars":
+			key, value, found := strings.Cut(line, "=")
+			if !found {
+				return fmt.Errorf("line %d: invalid variable %s, use key=value", number, line)
+			}
+			inventory.group(section).Vars[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+		}
+	}
+	return scanner.Err()
+}
+
+// splitIniLine splits a host line at the spaces outside of quotes and removes the quotes.
+func splitIniLine(line string) (fields []string, err error) {
+	var field strings.Builder
+	var quote rune
+	inField := false
+	for _, char := range line {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(char)
+		case char == '"' || char == '\'':
+			quote, inField = char, true
+		case char == ' ' || char == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		case char == '#' && !inField:
+			return fields, nil
+		default:
+			field.WriteRune(char)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return fields, fmt.Errorf("unclosed quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// unquote removes the quotes around a value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// expandHostRange expands a host pattern with a numeric or alphabetic range to the host names.
+func expandHostRange(pattern string) []string {
+	matches := hostRange.FindStringSubmatch(pattern)
+	if matches == nil {
+		return []string{pattern}
+	}
+	prefix, from, to, suffix := matches[1], matches[2], matches[3], matches[4]
+	var hosts []string
+	start, startErr := strconv.Atoi(from)
+	end, endErr := strconv.Atoi(to)
+	if startErr == nil && endErr == nil {
+		format := "%s%d%s"
+		if len(from) > 1 && from[0] == '0' {
+			format = "%s%0" + strconv.Itoa(len(from)) + "d%s"
+		}
+		for i := start; i <= end; i++ {
+			hosts = append(hosts, fmt.Sprintf(format, prefix, i, suffix))
+		}
+		return hosts
+	}
+	if len(from) == 1 && len(to) == 1 && startErr != nil && endErr != nil {
+		for char := from[0]; char <= to[0]; char++ {
+			hosts = append(hosts, prefix+string(char)+suffix)
+		}
+		return hosts
+	}
+	return []string{pattern}
+}
+
+// readVarsFiles reads the variables of a group or host from the vars folder: the file with the
+// name, with or without a .yml, .yaml or .json extension, or all the files of a folder with the name.
+func readVarsFiles(dir string, name string) (map[string]interface{}, error) {
+	var paths []string
+	for _, candidate := range []string{name, name + ".yml", name + ".yaml", name + ".json"} {
+		path := filepath.Join(dir, candidate)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			paths = append(paths, path)
+			continue
+		}
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, NewError(ConfigError, err, "can not read %s", path)
+		}
+		for _, file := range files {
+			if !file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+				paths = append(paths, filepath.Join(path, file.Name()))
+			}
+		}
+	}
+	vars := make(map[string]interface{})
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, NewError(ConfigError, err, "can not read %s", path)
+		}
+		if strings.HasPrefix(string(content), "$ANSIBLE_VAULT") {
+			return nil, NewError(ConfigError, nil, "%s is encrypted with ansible-vault, decrypt it before the import", path)
+		}
+		var fileVars map[string]interface{}
+		if err := yaml.Unmarshal(content, &fileVars); err != nil {
+			return nil, NewError(ConfigError, err, "invalid variables in %s", path)
+		}
+		for key, value := range fileVars {
+			vars[key] = normalizeYaml(value)
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles writes the files below the directory and creates the folders.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadStaticInventoryIni(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"hosts": `# the web servers
+lonely.example.com
+[web]
+web[01:02] http_port=80
+[db]
+db-a ansible_host=10.0.0.9 comment="primary db" # inline comment
+[db:vars]
+db_user = 'admin'
+[prod:children]
+web
+db
+`,
+		"group_vars/web.yml":         "http_port: 8080\nvhosts: [a, b]\n",
+		"group_vars/db/main.yml":     "db_port: 3306\n",
+		"host_vars/web01.yaml":       "backup: {enabled: true}\n",
+		"group_vars/prod/secret.yml": "$ANSIBLE_VAULT;1.1;AES256\n",
+	})
+	if _, err := ReadStaticInventory(filepath.Join(dir, "hosts")); ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error for the vault file, got %v", err)
+	}
+	os.Remove(filepath.Join(dir, "group_vars/prod/secret.yml"))
+
+	inventory, err := ReadStaticInventory(filepath.Join(dir, "hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts := inventory.HostNames(); !reflect.DeepEqual(hosts, []string{"db-a", "lonely.example.com", "web01", "web02"}) {
+		t.Errorf("unexpected hosts %v", hosts)
+	}
+	if groups := inventory.HostGroups("web01"); !reflect.DeepEqual(groups, []string{"prod", "web"}) {
+		t.Errorf("unexpected groups of web01 %v", groups)
+	}
+	if groups := inventory.HostGroups("lonely.example.com"); len(groups) != 0 {
+		t.Errorf("the ungrouped host should have no groups, got %v", groups)
+	}
+	expected := map[string]interface{}{"http_port": 8080, "vhosts": []interface{}{"a", "b"}}
+	if vars := inventory.Groups["web"].Vars; !reflect.DeepEqual(vars, expected) {
+		t.Errorf("unexpected web variables %v", vars)
+	}
+	expected = map[string]interface{}{"db_user": "admin", "db_port": 3306}
+	if vars := inventory.Groups["db"].Vars; !reflect.DeepEqual(vars, expected) {
+		t.Errorf("unexpected db variables %v", vars)
+	}
+	expected = map[string]interface{}{"ansible_host": "10.0.0.9", "comment": "primary db"}
+	if vars := inventory.Hosts["db-a"]; !reflect.DeepEqual(vars, expected) {
+		t.Errorf("unexpected db-a variables %v", vars)
+	}
+	expected = map[string]interface{}{"http_port": "80", "backup": map[string]interface{}{"enabled": true}}
+	if vars := inventory.Hosts["web01"]; !reflect.DeepEqual(vars, expected) {
+		t.Errorf("unexpected web01 variables %v", vars)
+	}
+}
+
+func TestReadStaticInventoryYaml(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"inventory.yml": `all:
+  vars:
+    ntp_server: ntp.example.com
+  children:
+    web:
+      hosts:
+        web[a:b]:
+          http_port: 80
+    prod:
+      children:
+        web:
+        db:
+          hosts:
+            db01:
+`})
+	inventory, err := ReadStaticInventory(filepath.Join(dir, "inventory.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts := inventory.HostNames(); !reflect.DeepEqual(hosts, []string{"db01", "weba", "webb"}) {
+		t.Errorf("unexpected hosts %v", hosts)
+	}
+	if groups := inventory.HostGroups("weba"); !reflect.DeepEqual(groups, []string{"prod", "web"}) {
+		t.Errorf("unexpected groups of weba %v", groups)
+	}
+	if inventory.Hosts["webb"]["http_port"] != 80 || inventory.Groups["all"].Vars["ntp_server"] != "ntp.example.com" {
+		t.Errorf("unexpected variables %v %v", inventory.Hosts, inventory.Groups["all"].Vars)
+	}
+}
+
+func TestExpandHostRange(t *testing.T) {
+	tests := map[string][]string{
+		"web[08:10].example.com": {"web08.example.com", "web09.example.com", "web10.example.com"},
+		"db[1:2]":                {"db1", "db2"},
+		"node-[x:z]":             {"node-x", "node-y", "node-z"},
+		"plain":                  {"plain"},
+	}
+	for pattern, expected := range tests {
+		if hosts := expandHostRange(pattern); !reflect.DeepEqual(hosts, expected) {
+			t.Errorf("%s: unexpected hosts %v", pattern, hosts)
+		}
+	}
+}
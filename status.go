@@ -0,0 +1,143 @@
+package main
+
+// A host is inactive when its binding is disabled in Almanac or when its device has a status
+// property other than active, ex. a2a-status=decommissioned. Every mode has its own action for
+// the inactive hosts: drop them, move them to the maintenance group or keep them with a label.
+// Almanac services can not be archived, a retired service has the same status property and
+// all its bindings are inactive.
+
+import (
+	"strings"
+)
+
+const (
+	StatusDrop        = "drop"
+	StatusMaintenance = "maintenance"
+	StatusLabel       = "label"
+)
+
+const (
+	// statusActive is the status of the hosts that are used in every mode.
+	statusActive = "active"
+	// statusDisabled is the status of the hosts with a disabled binding.
+	statusDisabled = "disabled"
+	// statusVar is the host variable with the status in the label action.
+	statusVar = "a2a_status"
+)
+
+// statusActions are the allowed actions for the inactive hosts.
+var statusActions = []string{StatusDrop, StatusMaintenance, StatusLabel}
+
+// StatusOptions are the settings of the [Status] section for one mode.
+type StatusOptions struct {
+	// Property is the device property with the status, ex. a2a-status.
+	Property string
+	// Action is drop, maintenance or label.
+	Action string
+	// Group is the group of the inactive hosts in the maintenance action.
+	Group string
+}
+
+// GetStatusOptions returns the status options of the given mode, which is inventory, prometheus or blackbox.
+func GetStatusOptions(Config Configuration, mode string) (options StatusOptions, err error) {
+	options.Property = Config.Status.Property
+	options.Group = Config.Status.Group
+	switch mode {
+	case "inventory":
+		options.Action = Config.Status.Inventory
+	case "prometheus":
+		options.Action = Config.Status.Prometheus
+	case "blackbox":
+		options.Action = Config.Status.Blackbox
+	}
+	if options.Action == "" {
+		options.Action = StatusDrop
+	}
+	if !containsString(statusActions, options.Action) {
+		return options, NewError(ConfigError, nil, "invalid status action %q for %s, use one of %s", options.Action, mode, strings.Join(statusActions, ", "))
+	}
+	return options, nil
+}
+
+// hostStatus returns the status of the host in the group. The disabled bindings win over the
+// device status, which wins over the status of the service.
+func (output *Output) hostStatus(group Group, host string, property string) string {
+	if status := group.Status[host]; status != "" {
+		return status
+	}
+	if status := varStatus(output.Meta.HostVars[host], property); status != "" && status != statusActive {
+		return status
+	}
+	return varStatus(group.Vars, property)
+}
+
+// varStatus returns the status property of the device or service variables.
+func varStatus(vars map[string]interface{}, property string) string {
+	status, _ := vars[ReplaceToUnderscore(property)].(string)
+	return strings.ToLower(strings.TrimSpace(status))
+}
+
+// ApplyStatus applies the action of the options to the inactive hosts. The dropped hosts which
+// are in no group anymore are removed from the host variables too.
+func (output *Output) ApplyStatus(options StatusOptions) {
+	moved := make(map[string]bool)
+	dropped := make(map[string]bool)
+	for _, name := range output.GroupNames() {
+		if name == options.Group && options.Action == StatusMaintenance {
+			continue
+		}
+		group := output.Group[name]
+		serviceStatus := varStatus(group.Vars, options.Property)
+		retired := serviceStatus != "" && serviceStatus != statusActive
+		if retired {
+			logger.Info("inactive service", "group", name, "status", serviceStatus, "action", options.Action)
+		}
+		hosts := make([]string, 0, len(group.Hosts))
+		for _, host := range group.Hosts {
+			status := output.hostStatus(group, host, options.Property)
+			if status == "" || status == statusActive {
+				hosts = append(hosts, host)
+				continue
+			}
+			logger.Info("inactive host", "host", host, "group", name, "status", status, "action", options.Action)
+			switch options.Action {
+			case StatusLabel:
+				hosts = append(hosts, host)
+				if group.Status == nil {
+					group.Status = make(map[string]string)
+				}
+				group.Status[host] = status
+				if vars := output.Meta.HostVars[host]; vars != nil {
+					if _, found := vars[statusVar]; !found {
+						vars[statusVar] = status
+					}
+				}
+			case StatusMaintenance:
+				moved[host] = true
+			case StatusDrop:
+				dropped[host] = true
+			}
+		}
+		group.Hosts = hosts
+		switch {
+		case retired && options.Action == StatusLabel:
+			if _, found := group.Vars[statusVar]; !found {
+				group.Vars[statusVar] = serviceStatus
+			}
+			output.Group[name] = group
+		case retired:
+			// The group of a retired service is removed with its hosts.
+			delete(output.Group, name)
+		default:
+			output.Group[name] = group
+		}
+	}
+	for _, host := range sortedKeys(moved) {
+		output.AddHost(host, options.Group)
+	}
+	for host := range dropped {
+		if !output.hasGroup(host) {
+			delete(output.Meta.HostVars, host)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testStatusOutput returns an inventory with a decommissioned device and a disabled binding of db01.
+func testStatusOutput() Output {
+	var output Output
+	output.Group = map[string]Group{
+		"web":    {Hosts: []string{"web01", "web02"}},
+		"db":     {Hosts: []string{"db01"}, Status: map[string]string{"db01": statusDisabled}},
+		"backup": {Hosts: []string{"db01"}},
+	}
+	output.Meta.HostVars = map[string]map[string]interface{}{
+		"web01": {"a2a_status": "active"},
+		"web02": {"a2a_status": "Decommissioned"},
+		"db01":  {},
+	}
+	return output
+}
+
+func TestApplyStatus(t *testing.T) {
+	tests := []struct {
+		action string
+		groups map[string][]string
+		vars   []string
+	}{
+		{StatusDrop, map[string][]string{"web": {"web01"}, "db": {}, "backup": {"db01"}}, []string{"db01", "web01"}},
+		{StatusMaintenance, map[string][]string{"web": {"web01"}, "db": {}, "backup": {"db01"}, "maintenance": {"db01", "web02"}}, []string{"db01", "web01", "web02"}},
+		{StatusLabel, map[string][]string{"web": {"web01", "web02"}, "db": {"db01"}, "backup": {"db01"}}, []string{"db01", "web01", "web02"}},
+	}
+	for _, test := range tests {
+		output := testStatusOutput()
+		Config := defaultConfig()
+		Config.Status.Inventory = test.action
+		options, err := GetStatusOptions(Config, "inventory")
+		if err != nil {
+			t.Fatal(err)
+		}
+		output.ApplyStatus(options)
+		groups := make(map[string][]string)
+		for name, group := range output.Group {
+			groups[name] = group.Hosts
+		}
+		if !reflect.DeepEqual(groups, test.groups) {
+			t.Errorf("%s: unexpected groups %v", test.action, groups)
+		}
+		if !reflect.DeepEqual(sortedKeys(output.Meta.HostVars), test.vars) {
+			t.Errorf("%s: unexpected hosts %v", test.action, sortedKeys(output.Meta.HostVars))
+		}
+	}
+}
+
+func TestApplyStatusLabel(t *testing.T) {
+	output := testStatusOutput()
+	db := output.Group["db"]
+	db.Vars = map[string]interface{}{"prometheus_config": `[{"name":"mysql","port":9104}]`}
+	db.Addresses = map[string]string{"db01": "10.0.0.5"}
+	output.Group["db"] = db
+	output.ApplyStatus(StatusOptions{Property: "a2a-status", Action: StatusLabel})
+	if output.Meta.HostVars["db01"][statusVar] != statusDisabled {
+		t.Errorf("expected the status variable, got %v", output.Meta.HostVars["db01"])
+	}
+	data, problems := GetPrometheusData(output, testJsonWrapper, nil)
+	if len(problems) != 0 {
+		t.Fatal(problems)
+	}
+	if len(data) != 1 || data[0].Labels["status"] != statusDisabled {
+		t.Errorf("expected the status label, got %v", data)
+	}
+}
+
+func TestGetStatusOptionsInvalidAction(t *testing.T) {
+	Config := defaultConfig()
+	Config.Status.Prometheus = "ignore"
+	_, err := GetStatusOptions(Config, "prometheus")
+	if ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error, got %v", err)
+	}
+}
+
+func TestApplyStatusRetiredService(t *testing.T) {
+	tests := []struct {
+		action string
+		groups map[string][]string
+		vars   []string
+	}{
+		{StatusDrop, map[string][]string{"web": {"web01"}}, []string{"web01"}},
+		{StatusMaintenance, map[string][]string{"web": {"web01"}, "maintenance": {"legacy01", "web01"}}, []string{"legacy01", "web01"}},
+		{StatusLabel, map[string][]string{"web": {"web01"}, "legacy": {"legacy01", "web01"}}, []string{"legacy01", "web01"}},
+	}
+	for _, test := range tests {
+		var output Output
+		output.Group = map[string]Group{
+			"web":    {Hosts: []string{"web01"}},
+			"legacy": {Hosts: []string{"legacy01", "web01"}, Vars: map[string]interface{}{"a2a_status": "Retired"}},
+		}
+		output.Meta.HostVars = map[string]map[string]interface{}{"web01": {"a2a_status": "active"}, "legacy01": {}}
+		output.ApplyStatus(StatusOptions{Property: "a2a-status", Action: test.action, Group: "maintenance"})
+		groups := make(map[string][]string)
+		for name, group := range output.Group {
+			groups[name] = group.Hosts
+		}
+		if !reflect.DeepEqual(groups, test.groups) {
+			t.Errorf("%s: unexpected groups %v", test.action, groups)
+		}
+		if !reflect.DeepEqual(sortedKeys(output.Meta.HostVars), test.vars) {
+			t.Errorf("%s: unexpected hosts %v", test.action, sortedKeys(output.Meta.HostVars))
+		}
+		if test.action == StatusLabel && output.Group["legacy"].Status["legacy01"] != "retired" {
+			t.Errorf("expected the status of the binding, got %v", output.Group["legacy"].Status)
+		}
+	}
+}
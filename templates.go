@@ -0,0 +1,223 @@
+package main
+
+// The property values can be Go templates over the variables of the host, ex. a service property
+// backup-target = {{ .host.name }}.backup.{{ .group.domain }}. As in every Go template the names
+// start with a dot. They are expanded per host, so the expanded group variables become host
+// variables. The templates are switched on with Template.Enabled, because the properties for
+// Ansible can contain Jinja expressions.
+
+import (
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// hostTemplateVar contains the name, groups and interface of the host in the templates.
+const hostTemplateVar = "host"
+
+// groupTemplateVar contains the variables of the groups of the host in the templates.
+const groupTemplateVar = "group"
+
+// ExpandTemplates expands the templated values of the host and group variables for every host.
+// The expanded values are host variables and the templated group variables are removed from
+// the groups with hosts. The groups without hosts keep them unexpanded. The values that can
+// not be expanded, ex. because of a cycle, are removed and returned as problems.
+func (output *Output) ExpandTemplates(Config Configuration) (problems []InventoryError) {
+	if !Config.Template.Enabled {
+		return problems
+	}
+	left, right := Config.Template.Left, Config.Template.Right
+	for _, host := range sortedKeys(output.Meta.HostVars) {
+		vars := output.varsOf(host)
+		expanded, failed := expandVars(vars, output.hostInfo(host), output.groupVarsOf(host), left, right)
+		for key, value := range expanded {
+			output.Meta.HostVars[host][key] = value
+		}
+		for _, problem := range failed {
+			delete(output.Meta.HostVars[host], problem.Property)
+			problem.Device = host
+			problems = append(problems, problem)
+		}
+	}
+	for _, name := range output.GroupNames() {
+		group := output.Group[name]
+		for _, key := range sortedKeys(group.Vars) {
+			if text, ok := group.Vars[key].(string); !ok || !strings.Contains(text, left) {
+				continue
+			}
+			if len(group.Hosts) == 0 {
+				logger.Warn("the templated group variable is not expanded, the group has no hosts", "group", name, "var", key)
+				continue
+			}
+			delete(group.Vars, key)
+			logger.Info("the templated group variable is moved to the hosts", "group", name, "var", key)
+		}
+	}
+	return problems
+}
+
+// groupVarsOf returns the variables of the groups of the host, without its own variables.
+func (output *Output) groupVarsOf(host string) map[string]interface{} {
+	vars := make(map[string]interface{})
+	for _, name := range output.GroupNames() {
+		group := output.Group[name]
+		if !containsString(group.Hosts, host) {
+			continue
+		}
+		for key, value := range group.Vars {
+			vars[key] = value
+		}
+	}
+	return vars
+}
+
+// hostInfo returns the data of .host in the templates: the name, the groups and the address and
+// network of the first binding.
+func (output *Output) hostInfo(host string) map[string]interface{} {
+	info := map[string]interface{}{"name": host}
+	groups := make([]interface{}, 0)
+	for _, name := range output.GroupNames() {
+		group := output.Group[name]
+		if !containsString(group.Hosts, host) {
+			continue
+		}
+		groups = append(groups, name)
+		if _, found := info["address"]; !found && group.Addresses[host] != "" {
+			info["address"] = group.Addresses[host]
+			info["network"] = group.Networks[host]
+		}
+	}
+	info["groups"] = groups
+	return info
+}
+
+// expandVars expands the string values with templates, the referenced templated values are
+// expanded first. The group variables are .group in the templates, their templated values are
+// expanded too unless the host has its own value. It returns the expanded values and a problem
+// for every value that failed.
+func expandVars(vars map[string]interface{}, info map[string]interface{}, groupVars map[string]interface{}, left string, right string) (expanded map[string]interface{}, problems []InventoryError) {
+	expanded = make(map[string]interface{})
+	pending := make(map[string]string)
+	group := make(map[string]interface{}, len(groupVars))
+	for key, value := range groupVars {
+		group[key] = value
+	}
+	data := make(map[string]interface{}, len(vars)+2)
+	for key, value := range vars {
+		data[key] = value
+		if text, ok := value.(string); ok && strings.Contains(text, left) {
+			pending[key] = text
+		}
+	}
+	data[hostTemplateVar] = info
+	data[groupTemplateVar] = group
+	if len(pending) == 0 {
+		return expanded, problems
+	}
+
+	const visiting, done = 1, 2
+	state := make(map[string]int)
+	failed := make(map[string]error)
+	var expand func(key string, path []string) error
+	expand = func(key string, path []string) error {
+		path = append(path, key)
+		switch state[key] {
+		case done:
+			return failed[key]
+		case visiting:
+			return NewError(DataError, nil, "cycle in the templated variables %s", strings.Join(path, " -> "))
+		}
+		state[key] = visiting
+		err := func() error {
+			tmpl, err := template.New(key).Delims(left, right).Funcs(templateFuncs).Option("missingkey=error").Parse(pending[key])
+			if err != nil {
+				return NewError(DataError, err, "invalid template in %s", key)
+			}
+			for _, ref := range templateRefs(tmpl.Tree.Root) {
+				if _, found := pending[ref]; found {
+					if err := expand(ref, path); err != nil {
+						return err
+					}
+				}
+			}
+			var text strings.Builder
+			if err := tmpl.Execute(&text, data); err != nil {
+				return NewError(DataError, err, "can not expand %s", key)
+			}
+			data[key] = text.String()
+			expanded[key] = text.String()
+			if value, found := group[key]; found && value == interface{}(pending[key]) {
+				group[key] = text.String()
+			}
+			return nil
+		}()
+		state[key] = done
+		failed[key] = err
+		return err
+	}
+	keys := sortedKeys(pending)
+	for _, key := range keys {
+		expand(key, nil)
+	}
+	for _, key := range keys {
+		if err := failed[key]; err != nil {
+			problems = append(problems, InventoryError{Property: key, Reason: err.Error(), err: err})
+		}
+	}
+	return expanded, problems
+}
+
+// templateRefs returns the variables a template refers to: the first name of the fields like
+// .domain or $.domain, the group variables like .group.domain and the strings, which can be
+// used as keys in index . "domain".
+func templateRefs(node parse.Node) (refs []string) {
+	switch typed := node.(type) {
+	case *parse.ListNode:
+		if typed == nil {
+			return refs
+		}
+		for _, item := range typed.Nodes {
+			refs = append(refs, templateRefs(item)...)
+		}
+	case *parse.ActionNode:
+		refs = append(refs, templateRefs(typed.Pipe)...)
+	case *parse.PipeNode:
+		if typed == nil {
+			return refs
+		}
+		for _, command := range typed.Cmds {
+			refs = append(refs, templateRefs(command)...)
+		}
+	case *parse.CommandNode:
+		for _, arg := range typed.Args {
+			refs = append(refs, templateRefs(arg)...)
+		}
+	case *parse.IfNode:
+		refs = append(refs, templateRefs(&typed.BranchNode)...)
+	case *parse.RangeNode:
+		refs = append(refs, templateRefs(&typed.BranchNode)...)
+	case *parse.WithNode:
+		refs = append(refs, templateRefs(&typed.BranchNode)...)
+	case *parse.BranchNode:
+		refs = append(refs, templateRefs(typed.Pipe)...)
+		refs = append(refs, templateRefs(typed.List)...)
+		refs = append(refs, templateRefs(typed.ElseList)...)
+	case *parse.ChainNode:
+		refs = append(refs, templateRefs(typed.Node)...)
+	case *parse.FieldNode:
+		refs = append(refs, typed.Ident[0])
+		if len(typed.Ident) > 1 && typed.Ident[0] == groupTemplateVar {
+			refs = append(refs, typed.Ident[1])
+		}
+	case *parse.VariableNode:
+		if len(typed.Ident) > 1 && typed.Ident[0] == "$" {
+			refs = append(refs, typed.Ident[1])
+		}
+		if len(typed.Ident) > 2 && typed.Ident[0] == "$" && typed.Ident[1] == groupTemplateVar {
+			refs = append(refs, typed.Ident[2])
+		}
+	case *parse.StringNode:
+		refs = append(refs, typed.Text)
+	}
+	return refs
+}
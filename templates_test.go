@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplates(t *testing.T) {
+	var output Output
+	output.Group = map[string]Group{
+		"web": {
+			Hosts:     []string{"web01", "web02"},
+			Vars:      map[string]interface{}{"domain": "example.com", "backup_target": "{{ .host.name }}.backup.{{ .domain }}"},
+			Addresses: map[string]string{"web01": "10.0.0.1"},
+		},
+		"spare": {Hosts: []string{}, Vars: map[string]interface{}{"backup_target": "{{ .host.name }}.backup"}},
+	}
+	output.Meta.HostVars = map[string]map[string]interface{}{
+		"web01": {
+			"url":     "https://{{ .fqdn }}/",
+			"backup":  "{{ .group.backup_target }}",
+			"fqdn":    "{{ .host.name }}.{{ .domain }}",
+			"listen":  "{{ .host.address }}:{{ index . \"port\" | default 80 }}",
+			"jinja":   "[[ not expanded ]]",
+			"cycle_a": "{{ .cycle_b }}",
+			"cycle_b": "{{ .cycle_a }}",
+		},
+		"web02": {"backup_target": "own.example.com", "missing": "{{ .unknown }}",
+			"domain": "web02.example.org", "group_domain": "{{ .group.domain }}"},
+	}
+	Config := defaultConfig()
+	Config.Template.Enabled = true
+	problems := output.ExpandTemplates(Config)
+
+	web01 := output.Meta.HostVars["web01"]
+	expected := map[string]interface{}{
+		"url":           "https://web01.example.com/",
+		"backup":        "web01.backup.example.com",
+		"fqdn":          "web01.example.com",
+		"listen":        "10.0.0.1:80",
+		"jinja":         "[[ not expanded ]]",
+		"backup_target": "web01.backup.example.com",
+	}
+	if !reflect.DeepEqual(web01, expected) {
+		t.Errorf("unexpected variables of web01 %v", web01)
+	}
+	web02 := output.Meta.HostVars["web02"]
+	if web02["backup_target"] != "own.example.com" {
+		t.Errorf("the host variable should win over the templated group variable, got %v", web02)
+	}
+	if web02["group_domain"] != "example.com" {
+		t.Errorf("expected the group variables in .group, got %v", web02)
+	}
+	if _, found := output.Group["web"].Vars["backup_target"]; found {
+		t.Error("the templated group variable should be moved to the hosts")
+	}
+	if output.Group["spare"].Vars["backup_target"] != "{{ .host.name }}.backup" {
+		t.Errorf("the group without hosts should keep its templated variable, got %v", output.Group["spare"].Vars)
+	}
+
+	var failed []string
+	for _, problem := range problems {
+		failed = append(failed, problem.Device+" "+problem.Property)
+	}
+	if !reflect.DeepEqual(failed, []string{"web01 cycle_a", "web01 cycle_b", "web02 missing"}) {
+		t.Errorf("unexpected problems %v", problems)
+	}
+	if !strings.Contains(problems[0].Reason, "cycle_a -> cycle_b -> cycle_a") {
+		t.Errorf("expected the cycle in the problem, got %s", problems[0].Reason)
+	}
+}
+
+func TestExpandTemplatesDisabled(t *testing.T) {
+	var output Output
+	output.Meta.HostVars = map[string]map[string]interface{}{"web01": {"fqdn": "{{ .host.name }}"}}
+	problems := output.ExpandTemplates(defaultConfig())
+	if len(problems) != 0 || output.Meta.HostVars["web01"]["fqdn"] != "{{ .host.name }}" {
+		t.Errorf("the templates should not be expanded, got %v", output.Meta.HostVars["web01"])
+	}
+}
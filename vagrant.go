@@ -0,0 +1,219 @@
+package main
+
+// In the Vagrant mode the machine is added to the groups targeted by the playbook. All the
+// plays and the imported playbooks are read and their host patterns are expanded to the
+// groups of the inventory. --vagrant-group replaces the groups of the playbook.
+
+import (
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// HostPatterns are the host patterns of a play. They can be given as a string like web:db
+// or as a list.
+type HostPatterns []string
+
+// UnmarshalYAML reads the patterns from a string or a list.
+func (patterns *HostPatterns) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*patterns = list
+		return nil
+	}
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	*patterns = HostPatterns{text}
+	return nil
+}
+
+// Terms splits the patterns in their single terms, ex. web:&db:!test to web, &db and !test.
+func (patterns HostPatterns) Terms() (terms []string) {
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "{{") {
+			terms = append(terms, pattern)
+			continue
+		}
+		for _, term := range strings.FieldsFunc(pattern, func(r rune) bool { return r == ':' || r == ',' }) {
+			if term = strings.TrimSpace(term); term != "" {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// importedPlaybook returns the path of the playbook imported by the play.
+func (play AnsiblePlaybook) importedPlaybook() string {
+	if play.ImportPlaybook != "" {
+		return play.ImportPlaybook
+	}
+	return play.BuiltinImportPlaybook
+}
+
+// ReadAnsiblePlayBook reads the given playbook from path and decode it to AnsiblePlaybook.
+// The plays of the imported playbooks are returned in their place.
+func ReadAnsiblePlayBook(path string) (playbook []AnsiblePlaybook, err error) {
+	return readPlaybook(path, make(map[string]bool))
+}
+
+// readPlaybook reads the playbook and its imports, importing is the chain of the imported playbooks.
+func readPlaybook(playbookPath string, importing map[string]bool) (playbook []AnsiblePlaybook, err error) {
+	absolutePath, err := filepath.Abs(playbookPath)
+	if err != nil {
+		return playbook, NewError(ConfigError, err, "can not read the playbook")
+	}
+	if importing[absolutePath] {
+		return playbook, NewError(ConfigError, nil, "the playbook %s imports itself", playbookPath)
+	}
+	importing[absolutePath] = true
+	defer delete(importing, absolutePath)
+
+	buffer, err := ioutil.ReadFile(playbookPath)
+	if err != nil {
+		return playbook, NewError(ConfigError, err, "can not read the playbook")
+	}
+	var plays []AnsiblePlaybook
+	err = yaml.Unmarshal(buffer, &plays)
+	if err != nil {
+		return playbook, NewError(ConfigError, err, "can not parse the playbook %s", playbookPath)
+	}
+	for _, play := range plays {
+		imported := play.importedPlaybook()
+		if imported == "" {
+			playbook = append(playbook, play)
+			continue
+		}
+		if strings.Contains(imported, "{{") {
+			logger.Warn("the templated import is skipped", "playbook", playbookPath, "import", imported)
+			continue
+		}
+		if !filepath.IsAbs(imported) {
+			imported = filepath.Join(filepath.Dir(playbookPath), imported)
+		}
+		importedPlays, err := readPlaybook(imported, importing)
+		if err != nil {
+			return playbook, err
+		}
+		playbook = append(playbook, importedPlays...)
+	}
+	return playbook, nil
+}
+
+// VagrantGroups expands the host patterns of the plays to the groups of the inventory. The excluded
+// terms (!group) are left out, for intersections (&group) the machine is added to both groups.
+// Terms like all, wildcards and regular expressions (~regex) are matched with the existing groups.
+func (output *Output) VagrantGroups(playbook []AnsiblePlaybook) (groups []string) {
+	add := func(group string) {
+		if !containsString(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	for _, play := range playbook {
+		for _, term := range play.Hosts.Terms() {
+			term = strings.TrimPrefix(term, "&")
+			switch {
+			case strings.HasPrefix(term, "!"), term == "all", term == "*", term == "localhost":
+				continue
+			case strings.Contains(term, "{{"):
+				logger.Warn("the templated host pattern is skipped", "pattern", term)
+			case strings.HasPrefix(term, "~"):
+				regex, err := regexp.Compile(term[1:])
+				if err != nil {
+					logger.Warn("the host pattern is not a valid regular expression", "pattern", term, "error", err)
+					continue
+				}
+				for _, name := range output.GroupNames() {
+					if regex.MatchString(sanitizeGroupName(name)) {
+						add(name)
+					}
+				}
+			case strings.ContainsAny(term, "*?["):
+				for _, name := range output.GroupNames() {
+					if matched, _ := path.Match(term, sanitizeGroupName(name)); matched {
+						add(name)
+					}
+				}
+			default:
+				add(output.findGroup(term))
+			}
+		}
+	}
+	return groups
+}
+
+// findGroup returns the name of the group for the given name from a playbook. The playbooks use
+// the names with underscores, so a group mysql-servers is found with mysql_servers too.
+func (output *Output) findGroup(name string) string {
+	if _, found := output.Group[name]; found {
+		return name
+	}
+	for _, groupName := range output.GroupNames() {
+		if sanitizeGroupName(groupName) == name {
+			return groupName
+		}
+	}
+	return name
+}
+
+// sanitizeGroupName returns the name of the group as it is written by Sanitize.
+func sanitizeGroupName(name string) string {
+	return ReplaceDotsToUnderscore(ReplaceToUnderscore(name))
+}
+
+// AddVagrantHost adds the vagrant machine to the groups targeted by the playbook or, when they
+// are given, to the given groups. When no group is found the machine is added to ungrouped.
+func (output *Output) AddVagrantHost(playBookPath string, vagrant string, groups []string) error {
+	var playbook []AnsiblePlaybook
+	if len(groups) == 0 {
+		var err error
+		playbook, err = readVagrantPlaybook(playBookPath)
+		if err != nil {
+			return err
+		}
+	}
+	output.addVagrantHost(playbook, vagrant, groups)
+	return nil
+}
+
+// readVagrantPlaybook reads the playbook of the Vagrant mode, which must contain plays.
+func readVagrantPlaybook(playBookPath string) ([]AnsiblePlaybook, error) {
+	playbook, err := ReadAnsiblePlayBook(playBookPath)
+	if err != nil {
+		return playbook, err
+	}
+	if len(playbook) == 0 {
+		return playbook, NewError(ConfigError, nil, "the playbook %s contains no plays", playBookPath)
+	}
+	return playbook, nil
+}
+
+// addVagrantHost adds the machine to the given groups or, without groups, to the groups of the playbook.
+func (output *Output) addVagrantHost(playbook []AnsiblePlaybook, vagrant string, groups []string) {
+	if len(groups) == 0 {
+		groups = output.VagrantGroups(playbook)
+	} else {
+		groups = append([]string{}, groups...)
+		for i, group := range groups {
+			groups[i] = output.findGroup(group)
+		}
+	}
+	if len(groups) == 0 {
+		groups = []string{ungroupedGroup}
+	}
+	for _, group := range groups {
+		output.AddHost(vagrant, group)
+	}
+	if output.Meta.HostVars == nil {
+		output.Meta.HostVars = make(map[string]map[string]interface{})
+	}
+	if output.Meta.HostVars[vagrant] == nil {
+		output.Meta.HostVars[vagrant] = make(map[string]interface{})
+	}
+	logger.Info("vagrant host added", "host", vagrant, "groups", strings.Join(groups, ","))
+}
@@ -0,0 +1,142 @@
+package main
+
+// A Vagrant mapping file adds several machines of a multi-machine Vagrant environment to the
+// inventory, every machine with its own groups and host variables. The mapping can be
+// generated from the output of vagrant ssh-config.
+
+import (
+	"bufio"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VagrantMapping is the content of a mapping file:
+//
+//	machines:
+//	  db:
+//	    groups: [mysql_servers]
+//	    vars:
+//	      ansible_host: 192.168.56.11
+//	  app: {}
+type VagrantMapping struct {
+	Machines map[string]VagrantMachine `yaml:"machines"`
+}
+
+// VagrantMachine are the groups and host variables of a machine. Without groups the machine is
+// added to the groups of the playbook.
+type VagrantMachine struct {
+	Groups []string               `yaml:"groups,omitempty"`
+	Vars   map[string]interface{} `yaml:"vars,omitempty"`
+}
+
+// sshConfigVars maps the options of vagrant ssh-config to the Ansible host variables.
+var sshConfigVars = map[string]string{
+	"HostName":     "ansible_host",
+	"Port":         "ansible_port",
+	"User":         "ansible_user",
+	"IdentityFile": "ansible_ssh_private_key_file",
+}
+
+// ReadVagrantMapping reads the mapping from the given path.
+func ReadVagrantMapping(path string) (mapping VagrantMapping, err error) {
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return mapping, NewError(ConfigError, err, "can not read the vagrant mapping")
+	}
+	err = yaml.UnmarshalStrict(buffer, &mapping)
+	if err != nil {
+		return mapping, NewError(ConfigError, err, "can not parse the vagrant mapping %s", path)
+	}
+	return mapping, nil
+}
+
+// AddVagrantMachines adds the machines of the mapping to the inventory. The playbook is only
+// read when a machine has no groups.
+func (output *Output) AddVagrantMachines(playBookPath string, mapping VagrantMapping) error {
+	var playbook []AnsiblePlaybook
+	names := make([]string, 0, len(mapping.Machines))
+	for name, machine := range mapping.Machines {
+		names = append(names, name)
+		if len(machine.Groups) == 0 && playbook == nil {
+			var err error
+			playbook, err = readVagrantPlaybook(playBookPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		machine := mapping.Machines[name]
+		output.addVagrantHost(playbook, name, machine.Groups)
+		for key, value := range machine.Vars {
+			output.Meta.HostVars[name][key] = normalizeYaml(value)
+		}
+	}
+	return nil
+}
+
+// ParseSSHConfig reads the output of vagrant ssh-config and returns the machines with their
+// connection as host variables.
+func ParseSSHConfig(r io.Reader) (mapping VagrantMapping, err error) {
+	mapping.Machines = make(map[string]VagrantMachine)
+	var machine string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		key, value := fields[0], strings.Trim(strings.Join(fields[1:], " "), "\"")
+		if key == "Host" {
+			machine = value
+			mapping.Machines[machine] = VagrantMachine{Vars: make(map[string]interface{})}
+			continue
+		}
+		hostVar, ok := sshConfigVars[key]
+		if !ok || machine == "" {
+			continue
+		}
+		if port, err := strconv.Atoi(value); err == nil && key == "Port" {
+			mapping.Machines[machine].Vars[hostVar] = port
+		} else {
+			mapping.Machines[machine].Vars[hostVar] = value
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return mapping, NewError(ConfigError, err, "can not read the ssh config")
+	}
+	if len(mapping.Machines) == 0 {
+		return mapping, NewError(ConfigError, nil, "the ssh config contains no machines")
+	}
+	return mapping, nil
+}
+
+// UpdateMapping replaces the host variables of the existing mapping with the ones of the given
+// mapping. The groups and the other variables of the existing machines are kept. A machine with
+// another ansible_host, ex. a private IP, keeps it and its port instead of the forwarded ones.
+func UpdateMapping(existing VagrantMapping, update VagrantMapping) VagrantMapping {
+	if existing.Machines == nil {
+		existing.Machines = make(map[string]VagrantMachine)
+	}
+	for name, machine := range update.Machines {
+		current := existing.Machines[name]
+		if current.Vars == nil {
+			current.Vars = make(map[string]interface{})
+		}
+		host, found := current.Vars["ansible_host"]
+		ownHost := found && host != machine.Vars["ansible_host"]
+		for key, value := range machine.Vars {
+			if ownHost && (key == "ansible_host" || key == "ansible_port") {
+				continue
+			}
+			current.Vars[key] = value
+		}
+		existing.Machines[name] = current
+	}
+	return existing
+}
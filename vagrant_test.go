@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestPlaybooks writes the given playbooks to a temporary folder and returns the folder.
+func writeTestPlaybooks(t *testing.T, playbooks map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range playbooks {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadAnsiblePlayBookImports(t *testing.T) {
+	dir := writeTestPlaybooks(t, map[string]string{
+		"site.yml": "- import_playbook: web.yml\n- hosts: [db, cache]\n  roles: [db]\n",
+		"web.yml":  "- hosts: mysql_servers:&web:!test\n  tasks: []\n- ansible.builtin.import_playbook: all.yml\n",
+		"all.yml":  "- hosts: all\n",
+	})
+	playbook, err := ReadAnsiblePlayBook(filepath.Join(dir, "site.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patterns []HostPatterns
+	for _, play := range playbook {
+		patterns = append(patterns, play.Hosts)
+	}
+	expected := []HostPatterns{{"mysql_servers:&web:!test"}, {"all"}, {"db", "cache"}}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("expected the plays %v, got %v", expected, patterns)
+	}
+}
+
+func TestReadAnsiblePlayBookImportCycle(t *testing.T) {
+	dir := writeTestPlaybooks(t, map[string]string{
+		"site.yml": "- import_playbook: web.yml\n",
+		"web.yml":  "- import_playbook: site.yml\n",
+	})
+	_, err := ReadAnsiblePlayBook(filepath.Join(dir, "site.yml"))
+	if ExitCode(err) != exitCodes[ConfigError] {
+		t.Errorf("expected a configuration error, got %v", err)
+	}
+}
+
+func TestAddVagrantHost(t *testing.T) {
+	dir := writeTestPlaybooks(t, map[string]string{
+		"site.yml": "- hosts: mysql_servers:&web:!test\n- hosts: ~^cache\n- hosts: [monitoring]\n",
+	})
+	var output Output
+	output.Group = map[string]Group{
+		"mysql-servers": {Hosts: []string{"db01"}},
+		"web":           {Hosts: []string{"web01"}},
+		"test":          {Hosts: []string{}},
+		"cache-redis":   {Hosts: []string{}},
+	}
+	output.Meta.HostVars = map[string]map[string]interface{}{}
+	err := output.AddVagrantHost(filepath.Join(dir, "site.yml"), "vm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range []string{"mysql-servers", "web", "cache-redis", "monitoring"} {
+		if !containsString(output.Group[group].Hosts, "vm") {
+			t.Errorf("expected vm in %s, got %v", group, output.Group[group].Hosts)
+		}
+	}
+	if containsString(output.Group["test"].Hosts, "vm") {
+		t.Error("vm should not be in the excluded group")
+	}
+	if _, ok := output.Meta.HostVars["vm"]; !ok {
+		t.Error("expected the host variables of vm")
+	}
+
+	err = output.AddVagrantHost("", "vm2", []string{"mysql_servers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsString(output.Group["mysql-servers"].Hosts, "vm2") || len(output.Group) != 5 {
+		t.Errorf("expected vm2 only in mysql-servers, got %v", output.Group)
+	}
+}
+
+const testSSHConfig = `Host db
+  HostName 127.0.0.1
+  User vagrant
+  Port 2222
+  IdentityFile "/home/vagrant/project/.vagrant/machines/db/virtualbox/private_key"
+
+Host app
+  HostName 127.0.0.1
+  User vagrant
+  Port 2200
+`
+
+func TestParseSSHConfig(t *testing.T) {
+	mapping, err := ParseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"ansible_host":                 "127.0.0.1",
+		"ansible_port":                 2222,
+		"ansible_user":                 "vagrant",
+		"ansible_ssh_private_key_file": "/home/vagrant/project/.vagrant/machines/db/virtualbox/private_key",
+	}
+	if !reflect.DeepEqual(mapping.Machines["db"].Vars, expected) {
+		t.Errorf("unexpected variables %v", mapping.Machines["db"].Vars)
+	}
+
+	existing := VagrantMapping{Machines: map[string]VagrantMachine{
+		"db":  {Groups: []string{"mysql_servers"}, Vars: map[string]interface{}{"ansible_host": "192.168.56.11"}},
+		"app": {Vars: map[string]interface{}{"ansible_host": "127.0.0.1", "ansible_port": 2201}},
+	}}
+	updated := UpdateMapping(existing, mapping)
+	db := updated.Machines["db"]
+	if db.Vars["ansible_host"] != "192.168.56.11" || db.Vars["ansible_port"] != nil || db.Vars["ansible_user"] != "vagrant" {
+		t.Errorf("expected the private IP to be kept, got %v", db.Vars)
+	}
+	if !reflect.DeepEqual(db.Groups, []string{"mysql_servers"}) {
+		t.Errorf("expected the groups to be kept, got %v", db.Groups)
+	}
+	if updated.Machines["app"].Vars["ansible_port"] != 2200 {
+		t.Errorf("expected the new port, got %v", updated.Machines["app"].Vars)
+	}
+}
+
+func TestAddVagrantMachines(t *testing.T) {
+	dir := writeTestPlaybooks(t, map[string]string{
+		"site.yml":    "- hosts: web\n",
+		"vagrant.yml": "machines:\n  db:\n    groups: [mysql_servers]\n    vars:\n      ansible_host: 192.168.56.11\n  app: {}\n",
+	})
+	mapping, err := ReadVagrantMapping(filepath.Join(dir, "vagrant.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output Output
+	output.Group = map[string]Group{"mysql-servers": {Hosts: []string{}}, "web": {Hosts: []string{}}}
+	output.Meta.HostVars = map[string]map[string]interface{}{}
+	err = output.AddVagrantMachines(filepath.Join(dir, "site.yml"), mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(output.Group["mysql-servers"].Hosts, []string{"db"}) || !reflect.DeepEqual(output.Group["web"].Hosts, []string{"app"}) {
+		t.Errorf("unexpected groups %v", output.Group)
+	}
+	if output.Meta.HostVars["db"]["ansible_host"] != "192.168.56.11" {
+		t.Errorf("unexpected host variables %v", output.Meta.HostVars["db"])
+	}
+}